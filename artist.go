@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+/* an album, single or compilation of an artist */
+type Release struct {
+	ID, Name, Type string
+	Date           string /* yyyy-mm-dd, or just yyyy when spotify doesn't know better */
+}
+
+/* discography filters (-release-types, -released-after and -released-before) */
+var (
+	releaseTypes   = []string{"album", "single", "compilation"}
+	releasedAfter  string
+	releasedBefore string
+)
+
+var allReleaseTypes = []string{"album", "single", "compilation", "appears_on"}
+
+/* albums first, so a track released as a single and on an album is kept with the album */
+func releaseOrder(releaseType string) int {
+	for i, t := range allReleaseTypes {
+		if t == releaseType {
+			return i
+		}
+	}
+
+	return len(allReleaseTypes)
+}
+
+func ArtistReleases(id string) ([]Release, error) {
+	var releases []Release
+	if wantsReleaseType("album") || wantsReleaseType("single") || wantsReleaseType("compilation") {
+		all, err := artistReleasePages(id, discographyInitialPath, discographyEndPath, "data.artistUnion.discography.all")
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, all...)
+	}
+
+	if wantsReleaseType("appears_on") {
+		appearsOn, err := artistReleasePages(id, appearsOnInitialPath, appearsOnEndPath, "data.artistUnion.relatedContent.appearsOn")
+		if err != nil {
+			return nil, err
+		}
+		for i := range appearsOn {
+			appearsOn[i].Type = "appears_on"
+		}
+		releases = append(releases, appearsOn...)
+	}
+
+	var filtered []Release
+	for _, release := range releases {
+		if isWantedRelease(release) {
+			filtered = append(filtered, release)
+		}
+	}
+
+	/* by type, then oldest first */
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return releaseLess(filtered[i], filtered[j])
+	})
+
+	return filtered, nil
+}
+
+func wantsReleaseType(releaseType string) bool {
+	for _, t := range releaseTypes {
+		if t == releaseType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func releaseLess(a, b Release) bool {
+	if releaseOrder(a.Type) != releaseOrder(b.Type) {
+		return releaseOrder(a.Type) < releaseOrder(b.Type)
+	}
+	return a.Date < b.Date
+}
+
+/* gets every page of a discography endpoint */
+func artistReleasePages(id, initialPath, endPath, listPath string) ([]Release, error) {
+	eConf := ResourceEndpoint{Limit: 50, Offset: 0}
+	var releases []Release
+
+	for {
+		endpointQuery := EncodeParam(fmt.Sprintf(`{"uri":"spotify:artist:%s","offset":%d,"limit":%d}`, id, eConf.Offset, eConf.Limit))
+		statusCode, jsonResponse, err := request(initialPath + endpointQuery + "&extensions=" + EncodeParam(endPath))
+		if err != nil {
+			return nil, fmt.Errorf("error getting releases: %w", err)
+		}
+
+		if statusCode != 200 {
+			return nil, fmt.Errorf("received non-200 status code: %d", statusCode)
+		}
+
+		eConf.TotalCount = gjson.Get(jsonResponse, listPath+".totalCount").Int()
+		for _, item := range gjson.Get(jsonResponse, listPath+".items").Array() {
+			release := item.Get("releases.items.0")
+			releases = append(releases, Release{
+				ID:   release.Get("id").String(),
+				Name: release.Get("name").String(),
+				Type: strings.ToLower(release.Get("type").String()),
+				Date: releaseDate(release.Get("date")),
+			})
+		}
+
+		eConf.pagination()
+		if eConf.Offset >= eConf.TotalCount {
+			break
+		}
+	}
+
+	return releases, nil
+}
+
+func isWantedRelease(release Release) bool {
+	releaseType := release.Type
+	if releaseType == "ep" {
+		releaseType = "single" /* spotify lists EPs among the singles */
+	}
+
+	after, before := normalizeDate(releasedAfter, "01-01"), normalizeDate(releasedBefore, "12-31")
+	date := normalizeDate(release.Date, "01-01")
+	return wantsReleaseType(releaseType) && (after == "" || date >= after) && (before == "" || date <= before)
+}
+
+/* "2019" -> "2019-01-01" (or "2019-12-31"), "2019-05" -> "2019-05-01" */
+func normalizeDate(date, fill string) string {
+	switch len(date) {
+	case 4:
+		return date + "-" + fill
+	case 7:
+		return date + fill[2:]
+	}
+	return date
+}
+
+/* parses "album,single" (-release-types) */
+func ParseReleaseTypes(s string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ReplaceAll(strings.TrimSpace(strings.ToLower(t)), "-", "_") /* appears-on too */
+		if releaseOrder(t) == len(allReleaseTypes) {
+			return nil, fmt.Errorf("unknown release type '%s' (album, single, compilation or appears_on)", t)
+		}
+		types = append(types, t)
+	}
+
+	return types, nil
+}
+
+/* validates -release-types, -released-after and -released-before */
+func setupDiscography() error {
+	if releasesF != "" {
+		types, err := ParseReleaseTypes(releasesF)
+		if err != nil {
+			return err
+		}
+		releaseTypes = types
+	}
+
+	for _, date := range []string{releasedAfter, releasedBefore} {
+		if date != "" && !isValidDate(date) {
+			return fmt.Errorf("invalid date '%s' (yyyy, yyyy-mm or yyyy-mm-dd)", date)
+		}
+	}
+
+	return nil
+}
+
+func isValidDate(date string) bool {
+	for _, layout := range []string{"2006", "2006-01", "2006-01-02"} {
+		if _, err := time.Parse(layout, date); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* a track appearing on several releases (single, album, deluxe edition...) is only downloaded once */
+func trackKey(track Track) string {
+	if track.ISRC != "" {
+		return track.ISRC
+	}
+
+	return fmt.Sprintf("%s|%d", RemoveAccents(strings.ToLower(track.Title)), int(track.Duration.Round(2*time.Second).Seconds()))
+}
+
+/* tracks of every wanted release, without duplicates */
+func artistTracks(id string) (map[Release][]Track, []Release, error) {
+	releases, err := ArtistReleases(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(releases) == 0 {
+		return nil, nil, errors.New("hum, there are no releases")
+	}
+
+	fmt.Fprintf(logOutput, "Releases found: %d\n", len(releases))
+
+	seen := make(map[string]bool)
+	byRelease := make(map[Release][]Track)
+	for _, release := range releases {
+		tracks, err := AlbumInfo(Resource{Kind: KindAlbum, ID: release.ID}.URL())
+		if err != nil {
+			yellow.Fprintf(logOutput, "Error collecting '%s': %v\n", release.Name, err)
+			continue
+		}
+
+		var unique []Track
+		for _, track := range tracks {
+			/* compilations of other artists, only the tracks of this one */
+			if release.Type == "appears_on" && !hasArtist(track, id) {
+				continue
+			}
+
+			key := trackKey(track)
+			if seen[key] || seen[track.ID] {
+				continue
+			}
+			seen[key], seen[track.ID] = true, true
+			unique = append(unique, track)
+		}
+
+		byRelease[release] = unique
+	}
+
+	return byRelease, releases, nil
+}
+
+func hasArtist(track Track, artistID string) bool {
+	for _, id := range track.ArtistIDs {
+		if id == artistID {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* for -explain */
+func ArtistInfo(url string) ([]Track, error) {
+	resource, err := parseResourceOf(url, KindArtist)
+	if err != nil {
+		return nil, err
+	}
+
+	byRelease, releases, err := artistTracks(resource.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	for _, release := range releases {
+		tracks = append(tracks, byRelease[release]...)
+	}
+
+	return tracks, nil
+}
+
+/* downloads the discography album by album, each one in its own folder */
+func dlArtist(url, savePath string) error {
+	resource, err := parseResourceOf(url, KindArtist)
+	if err != nil {
+		return err
+	}
+
+	byRelease, releases, err := artistTracks(resource.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, release := range releases {
+		tracks := byRelease[release]
+		if len(tracks) == 0 {
+			continue
+		}
+
+		folder, _ := correctFilename(release.Name, "")
+		albumPath := filepath.Join(savePath, folder) + string(filepath.Separator)
+		if err := os.MkdirAll(albumPath, 0755); err != nil {
+			return err
+		}
+
+		if err := saveAlbumCover(tracks, albumPath); err != nil {
+			yellow.Println("Error saving the album cover:", err)
+		}
+
+		fmt.Printf("\nNow, downloading '%s' (%s, %s)...\n", release.Name, strings.ReplaceAll(release.Type, "_", " "), release.Date)
+		if _, err := dlTrack(tracks, albumPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
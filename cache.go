@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* a previous match of a spotify track, stored in the user cache dir */
+type CachedMatch struct {
+	VideoID string    `json:"videoId"`
+	Ratio   float64   `json:"ratio"`
+	Path    MatchPath `json:"path"`
+	Time    time.Time `json:"time"`
+}
+
+type MatchCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]CachedMatch /* by spotify track ID */
+	dirty   bool
+}
+
+var (
+	cacheTTL       = 30 * 24 * time.Hour
+	refreshMatches bool /* -refresh-matches: don't read the cache, only update it */
+	matchCache     *MatchCache
+	matchCacheOnce sync.Once
+)
+
+/* ~/.cache/goffy/matches.json on linux */
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "goffy", "matches.json")
+}
+
+func LoadMatchCache(path string) *MatchCache {
+	cache := &MatchCache{path: path, entries: make(map[string]CachedMatch)}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cache.entries); err != nil {
+			yellow.Fprintln(logOutput, "Ignoring corrupted match cache:", path)
+			cache.entries = make(map[string]CachedMatch)
+		}
+	}
+
+	return cache
+}
+
+/* nil when there is no cache dir (or in the evaluation harness) */
+func getMatchCache() *MatchCache {
+	matchCacheOnce.Do(func() {
+		if path := defaultCachePath(); path != "" {
+			matchCache = LoadMatchCache(path)
+		}
+	})
+
+	return matchCache
+}
+
+func (c *MatchCache) Get(spotifyID string) (CachedMatch, bool) {
+	if c == nil || spotifyID == "" {
+		return CachedMatch{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[spotifyID]
+	if !ok || time.Since(entry.Time) > cacheTTL {
+		return CachedMatch{}, false
+	}
+
+	return entry, true
+}
+
+func (c *MatchCache) Put(spotifyID string, report *MatchReport) {
+	if c == nil || spotifyID == "" || report.Id == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[spotifyID] = CachedMatch{VideoID: report.Id, Ratio: report.Ratio, Path: report.Path, Time: time.Now()}
+	c.dirty = true
+}
+
+/* drops the expired entries and writes the cache if something changed */
+func (c *MatchCache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	for id, entry := range c.entries {
+		if time.Since(entry.Time) > cacheTTL {
+			delete(c.entries, id)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* optional settings read from a JSON file, flags take precedence over them */
+type Config struct {
+	Matcher   MatcherConfig `json:"matcher"`
+	Overrides string        `json:"overrides"` /* path to the overrides file */
+}
+
+type MatcherConfig struct {
+	Strategy   string   `json:"strategy"` /* levenshtein (default), jarowinkler or tokenset */
+	Weights    *Weights `json:"weights"`
+	Candidates int      `json:"candidates"`        /* results scored per YouTube Music page */
+	Pages      int      `json:"pages"`             /* pages requested per search query */
+	Blocked    []string `json:"blockedQualifiers"` /* live, remix, acoustic, sped up, slowed, karaoke, instrumental or cover */
+}
+
+/* ~/.config/goffy/config.json on linux, used when -config is not specified */
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "goffy", "config.json")
+}
+
+func LoadConfig(path string) (*Config, error) {
+	conf := &Config{}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return conf, nil /* the default config file is optional */
+		}
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("error parsing config file '%s': %w", path, err)
+	}
+
+	return conf, nil
+}
+
+/* builds the matcher used by Match from the config file and the -matcher/-weights flags */
+func setupMatcher(conf *Config) error {
+	strategy := conf.Matcher.Strategy
+	if matcherF != "" {
+		strategy = matcherF
+	}
+
+	switch strategy {
+	case "", "levenshtein", "jarowinkler", "tokenset":
+	default:
+		return fmt.Errorf("unknown matcher '%s'", strategy)
+	}
+
+	weights := defaultWeights
+	if conf.Matcher.Weights != nil {
+		weights = *conf.Matcher.Weights
+		for _, w := range []struct {
+			name   string
+			weight float64
+		}{
+			{"title", weights.Title},
+			{"artist", weights.Artist},
+			{"album", weights.Album},
+			{"duration", weights.Duration},
+		} {
+			if w.weight < 0 {
+				return fmt.Errorf("invalid weight '%s=%v' in the config file, weights can't be negative", w.name, w.weight)
+			}
+		}
+	}
+
+	if weightsF != "" {
+		var err error
+		if weights, err = ParseWeights(weightsF, weights); err != nil {
+			return err
+		}
+	}
+
+	if weights.Title+weights.Artist+weights.Album+weights.Duration <= 0 {
+		return errors.New("at least one matcher weight must be greater than 0")
+	}
+
+	blocked := defaultBlockedQualifiers
+	if conf.Matcher.Blocked != nil {
+		for _, name := range conf.Matcher.Blocked {
+			if _, ok := versionQualifiers[name]; !ok {
+				return fmt.Errorf("unknown qualifier '%s'", name)
+			}
+		}
+		blocked = conf.Matcher.Blocked
+	}
+
+	if blockF != "" {
+		var err error
+		if blocked, err = ParseQualifiers(blockF); err != nil {
+			return err
+		}
+	}
+
+	matcher = NewMatcher(strategy, weights, blocked)
+
+	if candidatesF < 0 || pagesF < 0 || conf.Matcher.Candidates < 0 || conf.Matcher.Pages < 0 {
+		return errors.New("the number of candidates and pages must be positive")
+	}
+
+	if ambiguousF < 0 || ambiguousF > 1 {
+		return errors.New("-ambiguous must be between 0 and 1")
+	}
+	ambiguousRatio = ambiguousF
+
+	searchCandidates = firstPositive(candidatesF, conf.Matcher.Candidates, searchCandidates)
+	searchPages = firstPositive(pagesF, conf.Matcher.Pages, searchPages)
+	return nil
+}
+
+/* flags first, then the config file, then the default */
+func firstPositive(values ...int) int {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+
+	return 0
+}
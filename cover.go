@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+/* one of the sizes of an album cover (spotify has 64, 300 and 640 pixels wide) */
+type CoverArt struct {
+	URL           string
+	Width, Height int
+}
+
+const albumCoverFile = "cover.jpg"
+
+/* widest cover of each size, 0 is the largest available */
+var coverSizes = map[string]int{"small": 64, "medium": 300, "large": 0}
+
+var (
+	coverSize = "large" /* -cover-size: small, medium, large or none */
+	covers    = struct {
+		sync.Mutex
+		data map[string][]byte /* by URL, the tracks of an album share their cover */
+	}{data: make(map[string][]byte)}
+	/* the album of the cover.jpg written in each folder during this run, "" once it got several */
+	folderCovers = struct {
+		sync.Mutex
+		albums map[string]string
+	}{albums: make(map[string]string)}
+)
+
+/* sources of the form {"url": "https://i.scdn.co/image/...", "width": 640, "height": 640} */
+func (t *Track) setCovers(sources gjson.Result) {
+	for _, source := range sources.Array() {
+		if url := source.Get("url").String(); url != "" {
+			t.Covers = append(t.Covers, CoverArt{URL: url, Width: int(source.Get("width").Int()), Height: int(source.Get("height").Int())})
+		}
+	}
+
+	/* largest first */
+	sort.SliceStable(t.Covers, func(i, j int) bool {
+		return t.Covers[i].Width > t.Covers[j].Width
+	})
+}
+
+/* validates -cover-size, mobile downloads default to medium covers */
+func setupCovers() error {
+	switch {
+	case coverSizeF == "" && mobileF:
+		coverSize = "medium"
+	case coverSizeF != "":
+		coverSize = coverSizeF
+	}
+
+	if _, ok := coverSizes[coverSize]; !ok && coverSize != "none" {
+		return fmt.Errorf("unknown cover size '%s' (small, medium, large or none)", coverSize)
+	}
+
+	return nil
+}
+
+/* the largest cover not wider than the chosen size, or the smallest one if all of them are */
+func pickCover(covers []CoverArt, size string) (CoverArt, bool) {
+	maxWidth, ok := coverSizes[size]
+	if !ok || len(covers) == 0 {
+		return CoverArt{}, false
+	}
+
+	for _, cover := range covers {
+		if maxWidth == 0 || cover.Width <= maxWidth {
+			return cover, true
+		}
+	}
+
+	return covers[len(covers)-1], true
+}
+
+func fetchCover(url string) ([]byte, error) {
+	covers.Lock()
+	data, ok := covers.data[url]
+	covers.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	requestLimiter.Wait()
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cover: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cover: %w", err)
+	}
+
+	covers.Lock()
+	covers.data[url] = data
+	covers.Unlock()
+
+	return data, nil
+}
+
+/* the cover of the track in -cover-size, nil if there is none */
+func TrackCover(track Track) ([]byte, error) {
+	cover, ok := pickCover(track.Covers, coverSize)
+	if !ok {
+		return nil, nil
+	}
+
+	return fetchCover(cover.URL)
+}
+
+/* writes the cover of the track to a temporary file for ffmpeg, "" if there is none */
+func coverTempFile(track Track) (string, error) {
+	data, err := TrackCover(track)
+	if err != nil || data == nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp("", "goffy-cover-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+/*
+-cover-file: cover.jpg in the folder of an album, always the largest size.
+the cover of another album is replaced, and a folder that gets several albums in the same run (goffy albumA albumB -d dir)
+has no cover.jpg, since no cover is the right one
+*/
+func saveAlbumCover(tracks []Track, dir string) error {
+	if !coverFileF || len(tracks) == 0 || len(tracks[0].Covers) == 0 {
+		return nil
+	}
+
+	file := filepath.Join(dir, albumCoverFile)
+	album := tracks[0].AlbumID
+	if album == "" {
+		album = tracks[0].Album
+	}
+
+	folderCovers.Lock()
+	previous, ok := folderCovers.albums[filepath.Clean(dir)]
+	if ok && previous != album {
+		album = "" /* for good, whatever album comes next */
+	}
+	folderCovers.albums[filepath.Clean(dir)] = album
+	folderCovers.Unlock()
+
+	if album == "" {
+		fmt.Printf("The folder has tracks of several albums, %s was not saved\n", albumCoverFile)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := fetchCover(tracks[0].Covers[0].URL)
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 {
+		return errors.New("empty cover")
+	}
+
+	if current, err := os.ReadFile(file); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	return os.WriteFile(file, data, 0644)
+}
@@ -4,14 +4,12 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -31,8 +29,7 @@ func dlSingleTrack(url, savePath string) error {
 	track := []Track{*trackInfo}
 
 	fmt.Println("Now, downloading track...")
-	err = dlTrack(track, savePath)
-	if err != nil {
+	if _, err := dlTrack(track, savePath); err != nil {
 		return err
 	}
 
@@ -47,8 +44,7 @@ func dlPlaylist(url, savePath string) error {
 
 	time.Sleep(1 * time.Second)
 	fmt.Println("Now, downloading playlist...")
-	err = dlTrack(tracks, savePath)
-	if err != nil {
+	if _, err := dlTrack(tracks, savePath); err != nil {
 		fmt.Println(err)
 		return err
 	}
@@ -62,10 +58,13 @@ func dlAlbum(url, savePath string) error {
 		return err
 	}
 
+	if err := saveAlbumCover(tracks, savePath); err != nil {
+		yellow.Println("Error saving the album cover:", err)
+	}
+
 	time.Sleep(1 * time.Second)
 	fmt.Println("Now, downloading album...")
-	err = dlTrack(tracks, savePath)
-	if err != nil {
+	if _, err := dlTrack(tracks, savePath); err != nil {
 		return err
 	}
 
@@ -79,27 +78,27 @@ func dlFromTxt(file, savePath string) error {
 	}
 
 	fmt.Println("Now, downloading tracks...")
-	err = dlTrack(tracks, savePath)
-	if err != nil {
+	if _, err := dlTrack(tracks, savePath); err != nil {
 		return err
 	}
 
+	totals.print()
 	return nil
 }
 
 func processTxt(file string) ([]Track, error) {
 	/* first check if it is a txt */
 	if !IsTxt(file) {
-		return nil, errors.New("file is not a txt")
+		return nil, fmt.Errorf("%w: file is not a txt", errInvalidInput)
 	}
 
 	/* check if it is empty */
 	txtSize, _ := GetFileSize(file)
 	if txtSize <= 0 {
-		return nil, errors.New("file is empty")
+		return nil, fmt.Errorf("%w: file is empty (or doesn't exist)", errInvalidInput)
 	}
 
-	fmt.Println("Getting tracks' info...")
+	fmt.Fprintln(logOutput, "Getting tracks' info...")
 	txt, err := os.Open(file)
 	if err != nil {
 		panic(err)
@@ -107,152 +106,188 @@ func processTxt(file string) ([]Track, error) {
 	defer txt.Close()
 
 	scanner := bufio.NewScanner(txt)
-	var wg sync.WaitGroup
-	var tracks []Track
-
+	var urls []string
 	for scanner.Scan() {
-		line := scanner.Text()
-		wg.Add(1)
-		go func(line string) {
-			defer wg.Done()
-			track, err := TrackInfo(line)
-			if err != nil {
-				yellow.Printf("(URL: %s) - Error obtaining track information: %v\n", line, err)
-				return
-			}
-			tracks = append(tracks, *track)
-		}(line)
-	}
-
-	wg.Wait()
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			urls = append(urls, line)
+		}
+	}
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading file:", err)
+		fmt.Fprintln(logOutput, "Error reading file:", err)
 	}
 
-	fmt.Println("Tracks' info collected:", len(tracks))
+	tracks := lookupTracks(urls)
+	fmt.Fprintln(logOutput, "Tracks' info collected:", len(tracks))
 	return tracks, nil
 }
 
-func dlTrack(tracks []Track, path string) error {
-	var wg sync.WaitGroup
-	var totalTracks int
-	results := make(chan int, len(tracks))
-	numCPUs := runtime.NumCPU()
-	semaphore := make(chan struct{}, numCPUs)
-
-	for _, t := range tracks {
-		wg.Add(1)
-		go func(track Track) {
-			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() {
-				<-semaphore
-			}()
-
-			trackCopy := &Track{
-				Title:  track.Title,
-				Artist: track.Artist,
-				Album:  track.Album,
-			}
-
-			id, err := VideoID(*trackCopy)
-			if id == "" || err != nil {
-				yellow.Printf("Error (1): '%s' by '%s' could not be downloaded\n", trackCopy.Title, trackCopy.Artist)
-				return
-			}
-
-			trackCopy.Title, trackCopy.Artist = correctFilename(trackCopy.Title, trackCopy.Artist)
-			err = getAudio(id, path, trackCopy.Title, trackCopy.Artist)
-			if err != nil {
-			    fmt.Println(err)
-				yellow.Printf("Error (2): '%s' by '%s' could not be downloaded\n", trackCopy.Title, trackCopy.Artist)
-				return
-			}
-
-			trackCopy.Title, trackCopy.Artist = correctFilename(trackCopy.Title, trackCopy.Artist)
-			filePath := fmt.Sprintf("%s%s - %s.m4a", path, trackCopy.Title, trackCopy.Artist)
-
-			if err := addTags(filePath, *trackCopy); err != nil {
-				yellow.Println("Error adding tags: ", filePath)
-				return
-			}
-
-			size, _ := GetFileSize(filePath)
-			if size < 1 {
-				DeleteResource(filePath)
-			}
-
-			fmt.Printf("'%s' by '%s' was downloaded\n", track.Title, track.Artist)
-			results <- 1
-		}(t)
+/* downloads every link, tracks, albums and playlists can be mixed */
+func dlResources(urls []string, savePath string) error {
+	for _, url := range urls {
+		fmt.Println()
+		boldWhite.Println(url)
+
+		err := dlResource(url, savePath)
+		if err != nil {
+			yellow.Println(err)
+		}
+		totals.addLink(err)
+	}
+
+	totals.print()
+	return nil
+}
+
+func dlResource(url, savePath string) error {
+	resource, err := ParseResource(url)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidInput, err)
 	}
 
+	switch resource.Kind {
+	case KindPlaylist:
+		return dlPlaylist(resource.URL(), savePath)
+	case KindAlbum:
+		return dlAlbum(resource.URL(), savePath)
+	case KindArtist:
+		return dlArtist(resource.URL(), savePath)
+	}
+
+	return dlSingleTrack(resource.URL(), savePath)
+}
+
+/* downloads the tracks through the pipeline, the outcome of each one is in the result (and in the totals of the run) */
+func dlTrack(tracks []Track, path string) (*DownloadResult, error) {
+	start := time.Now()
+	result := &DownloadResult{}
+	review := &reviewLog{}
+	tagged := taggedFiles(path)
+
+	searches := make(chan *trackJob)
+	downloads := make(chan *trackJob)
+	tagging := make(chan *trackJob)
+	finished := make(chan *trackJob)
+
 	go func() {
-		wg.Wait()
-		close(results)
+		for _, track := range tracks {
+			searches <- newTrackJob(track)
+		}
+		close(searches)
 	}()
 
-	for result := range results {
-		totalTracks += result
+	runWorkers(searchWorkers, searches, func(job *trackJob) {
+		if job.search(path, tagged, review) {
+			downloads <- job
+		} else {
+			finished <- job
+		}
+	}, func() { close(downloads) })
+
+	runWorkers(downloadWorkers, downloads, func(job *trackJob) {
+		if job.download(path) {
+			tagging <- job
+		} else {
+			finished <- job
+		}
+	}, func() { close(tagging) })
+
+	/* tagging and converting don't need the network, they have their own workers */
+	runWorkers(tagWorkers, tagging, func(job *trackJob) {
+		job.tag()
+		finished <- job
+	}, func() { close(finished) })
+
+	for job := range finished {
+		job.result.Elapsed = time.Since(job.start)
+		result.add(job.result)
 	}
+	result.Elapsed = time.Since(start)
 
-	fmt.Println("Total tracks downloaded:", totalTracks)
-	return nil
+	fmt.Println("Total tracks downloaded:", result.Downloaded)
+	if n := result.Existing + result.Skipped; n > 0 {
+		fmt.Printf("Tracks skipped: %d (%d already existed)\n", n, result.Existing)
+	}
+	totals.add(result)
+
+	if err := getMatchCache().Save(); err != nil {
+		yellow.Println("Error saving the match cache:", err)
+	}
+
+	if file, err := review.Save(path); err != nil {
+		yellow.Println("Error saving ambiguous matches:", err)
+	} else if file != "" {
+		fmt.Printf("%d ambiguous matches to review in %s\n", len(review.entries), file)
+	}
 
+	if file, err := failuresOf(result).Save(path); err != nil {
+		yellow.Println("Error saving the failed tracks:", err)
+	} else if file != "" {
+		fmt.Printf("Failed tracks are listed in %s (goffy retry %s)\n", file, file)
+	}
+
+	return result, nil
 }
 
 /* github.com/kkdai/youtube */
-func getAudio(id, path, title, artist string) error {
+/* returns the path of the file, .m4a (or .webm for opus audio) */
+func getAudio(id, path, title, artist string) (string, error) {
 	dir, err := os.Stat(path)
 	if err != nil {
 		panic(err)
 	}
 
 	if !dir.IsDir() {
-		return errors.New("the path is not valid (not a dir)")
+		return "", errors.New("the path is not valid (not a dir)")
 	}
 
 	client := youtube.Client{}
-	video, err := client.GetVideo(id)
+	var video *youtube.Video
+	err = retryPolicy.Do(func() error {
+		requestLimiter.Wait()
+		video, err = client.GetVideo(id)
+		return classifyVideoError(err)
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	/* itag code: 140, container: m4a, content: audio, bitrate: 128k */
-	/* change the FindByItag parameter to 139 if you want smaller files (but with a bitrate of 48k) */
-	formats := video.Formats.Itag(140)
+	/* -quality, itag 140 (m4a, AAC, 128k) by default */
+	format, err := pickAudioFormat(video.Formats, audioQuality, outputFormat.Codec == nil)
+	if err != nil {
+		return "", fmt.Errorf("%w (video %s)", err, id)
+	}
 
-	filename := fmt.Sprintf("%s - %s.m4a", title, artist)
+	filename := fmt.Sprintf("%s - %s.%s", title, artist, formatExt(format))
 	route := filepath.Join(path, filename)
 
-	/* in some cases, when attempting to download the audio
-	using the library github.com/kkdai/youtube,
-	the download fails (and shows the file size as 0 bytes)
-	until the second or third attempt. */
-	var fileSize int64
-	file, err := os.Create(route)
-	if err != nil {
-		return err
+	/* the download sometimes fails (0 bytes) or is interrupted, it's resumed instead of started again */
+	if err := downloadStream(&client, video, format, route); err != nil {
+		return "", err
 	}
 
-	for fileSize == 0 {
-		stream, _, err := client.GetStream(video, &formats[0])
-		if err != nil {
-			return err
-		}
+	return route, nil
+}
 
-		if _, err = io.Copy(file, stream); err != nil {
-			return err
-		}
+/* tags are written natively, ffmpeg (if installed) is only the fallback for files the native writer can't handle */
+func addTags(file string, track Track) error {
+	cover, err := TrackCover(track)
+	if err != nil {
+		yellow.Printf("Error getting the cover of '%s': %v\n", track.Title, err)
+	}
 
-		fileSize, _ = GetFileSize(route)
+	err = WriteMP4Tags(file, mp4TagsOf(track, cover))
+	if err == nil {
+		return nil
 	}
-	defer file.Close()
 
-	return nil
+	if _, lookErr := exec.LookPath("ffmpeg"); lookErr != nil {
+		return err
+	}
+
+	return addTagsFFmpeg(file, track)
 }
 
-func addTags(file string, track Track) error {
+func addTagsFFmpeg(file string, track Track) error {
 	tempFile := file
 	index := strings.Index(file, ".m4a")
 	if index != -1 {
@@ -260,16 +295,25 @@ func addTags(file string, track Track) error {
 		tempFile = result + "2" + ".m4a" /* just a temporary dumb name ('/path/to/title - artist2.m4a') */
 	}
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", file, /* /path/to/title - artist.m4a */
-		"-c", "copy",
-		"-metadata", fmt.Sprintf("album_artist=%s", track.Artist),
-		"-metadata", fmt.Sprintf("title=%s", track.Title),
-		"-metadata", fmt.Sprintf("artist=%s", track.Artist),
-		"-metadata", fmt.Sprintf("album=%s", track.Album),
-		tempFile, /* /path/to/title - artist2.m4a */
-	)
+	args := []string{"-i", file} /* /path/to/title - artist.m4a */
+
+	/* a file without cover is better than no file */
+	cover, err := coverTempFile(track)
+	if err != nil {
+		yellow.Printf("Error getting the cover of '%s': %v\n", track.Title, err)
+	}
+	if cover != "" {
+		defer os.Remove(cover)
+		args = append(args, "-i", cover, "-map", "0:a", "-map", "1", "-disposition:v", "attached_pic")
+	}
+	args = append(args, "-c", "copy")
+
+	for _, tag := range trackTags(track) {
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", tag[0], tag[1]))
+	}
+	args = append(args, tempFile) /* /path/to/title - artist2.m4a */
+
+	cmd := exec.Command("ffmpeg", args...)
 
 	if err := cmd.Run(); err != nil {
 		return err
@@ -283,6 +327,60 @@ func addTags(file string, track Track) error {
 	return nil
 }
 
+/* ffmpeg metadata keys and values, empty ones are left out */
+func trackTags(track Track) [][2]string {
+	tags := [][2]string{
+		{"title", track.Title},
+		{"artist", strings.Join(track.Artists, ", ")},
+		{"album_artist", track.AlbumArtist},
+		{"album", track.Album},
+		{"date", track.ReleaseDate},
+		{"track", numberOfTotal(track.TrackNumber, track.TrackTotal)},
+		{"disc", numberOfTotal(track.DiscNumber, track.DiscTotal)},
+		{"comment", tagComment(track)},
+	}
+
+	var nonEmpty [][2]string
+	for _, tag := range tags {
+		if tag[1] != "" {
+			nonEmpty = append(nonEmpty, tag)
+		}
+	}
+
+	return nonEmpty
+}
+
+/* "3/12", "3" when the total is unknown */
+func numberOfTotal(n, total int) string {
+	switch {
+	case n < 1:
+		return ""
+	case total < n:
+		return fmt.Sprint(n)
+	}
+
+	return fmt.Sprintf("%d/%d", n, total)
+}
+
+/* the mp4 muxer of ffmpeg has no keys for these, so they go into the comment */
+func tagComment(track Track) string {
+	var parts []string
+	if track.Explicit {
+		parts = append(parts, "Explicit")
+	}
+	if track.ID != "" {
+		parts = append(parts, "spotify:track:"+track.ID)
+	}
+	if track.AlbumID != "" {
+		parts = append(parts, "spotify:album:"+track.AlbumID)
+	}
+	if track.ISRC != "" {
+		parts = append(parts, "ISRC "+track.ISRC)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
 /* fixes some invalid file names (windows is the capricious one) */
 func correctFilename(title, artist string) (string, string) {
 	if runtime.GOOS == "windows" {
@@ -376,6 +474,10 @@ func (dm MobileDownloader) MDownloader(url string, downloadFunc func(string, str
 	return nil
 }
 
+func (dd DesktopDownloader) Resources(urls []string, savePath string) error {
+	return dd.DDownloader("", func(_, path string) error { return dlResources(urls, path) }, savePath)
+}
+
 func (dd DesktopDownloader) Track(url string, savePath ...string) error {
 	return dd.DDownloader(url, dlSingleTrack, savePath...)
 }
@@ -392,6 +494,10 @@ func (dd DesktopDownloader) FromTxt(file string, savePath ...string) error {
 	return dd.DDownloader(file, dlFromTxt, savePath...)
 }
 
+func (dm MobileDownloader) Resources(urls []string) error {
+	return dm.MDownloader("", func(_, path string) error { return dlResources(urls, path) })
+}
+
 func (dm MobileDownloader) Track(url string) error {
 	return dm.MDownloader(url, dlSingleTrack)
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/raitonoberu/ytmusic"
+)
+
+/* the golden dataset ships with the binary, so goffy eval works wherever it was installed */
+//go:embed testdata/matching/*.json
+var evalFixtures embed.FS
+
+/*
+a golden case of the evaluation harness (testdata/matching):
+a Spotify track, the YouTube Music responses recorded for each
+search query (one element per page) and the video that should win
+(empty when no candidate should be accepted)
+*/
+type EvalCase struct {
+	Name      string                             `json:"name"`
+	Track     EvalTrack                          `json:"track"`
+	Expected  string                             `json:"expected"`
+	Responses map[string][]*ytmusic.SearchResult `json:"responses"`
+	file      string
+}
+
+type EvalTrack struct {
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Duration int    `json:"duration"` /* seconds */
+	ISRC     string `json:"isrc"`
+}
+
+type EvalResult struct {
+	Case EvalCase
+	Got  string
+	Err  error
+}
+
+/* replays the recorded pages of a query, an unknown query has no results */
+type replaySearch struct {
+	pages []*ytmusic.SearchResult
+	next  int
+}
+
+func (r *replaySearch) NextExists() bool {
+	return r.next < len(r.pages)
+}
+
+func (r *replaySearch) Next() (*ytmusic.SearchResult, error) {
+	if !r.NextExists() {
+		return nil, errors.New("end reached")
+	}
+
+	page := r.pages[r.next]
+	r.next++
+	return page, nil
+}
+
+/* the *.json fixtures of a folder (os.DirFS) or of the built-in dataset */
+func LoadEvalCases(fixtures fs.FS) ([]EvalCase, error) {
+	files, err := fs.Glob(fixtures, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no fixtures found")
+	}
+
+	sort.Strings(files)
+	var cases []EvalCase
+	for _, file := range files {
+		data, err := fs.ReadFile(fixtures, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var evalCase EvalCase
+		if err := json.Unmarshal(data, &evalCase); err != nil {
+			return nil, fmt.Errorf("error parsing fixture '%s': %w", file, err)
+		}
+		evalCase.file = file
+		cases = append(cases, evalCase)
+	}
+
+	return cases, nil
+}
+
+/* runs VideoID over every case without touching the network */
+func RunEval(cases []EvalCase) []EvalResult {
+	search := newTrackSearch
+	defer func() { newTrackSearch = search }()
+
+	/* the user's overrides and cached matches don't apply to the golden dataset */
+	overridesOnce.Do(func() {})
+	overrides = Overrides{}
+	matchCacheOnce.Do(func() {})
+	matchCache = nil
+
+	var results []EvalResult
+	for _, evalCase := range cases {
+		responses := evalCase.Responses
+		newTrackSearch = func(query string) trackSearcher {
+			return &replaySearch{pages: responses[query]}
+		}
+
+		track := Track{
+			Title:    evalCase.Track.Title,
+			Artist:   evalCase.Track.Artist,
+			Album:    evalCase.Track.Album,
+			Duration: time.Duration(evalCase.Track.Duration) * time.Second,
+			ISRC:     evalCase.Track.ISRC,
+		}
+
+		id, err := VideoID(track)
+		results = append(results, EvalResult{Case: evalCase, Got: id, Err: err})
+	}
+
+	return results
+}
+
+/* precision: correct matches among the returned ones, recall: correct matches among the expected ones */
+func evalScores(results []EvalResult) (precision, recall float64) {
+	var correct, returned, expected int
+	for _, result := range results {
+		if result.Got != "" {
+			returned++
+		}
+		if result.Case.Expected != "" {
+			expected++
+			if result.Got == result.Case.Expected {
+				correct++
+			}
+		}
+	}
+
+	if returned > 0 {
+		precision = float64(correct) / float64(returned)
+	}
+	if expected > 0 {
+		recall = float64(correct) / float64(expected)
+	}
+
+	return precision, recall
+}
+
+/* a case fails when it errors or doesn't get the expected video */
+func (r EvalResult) Failed() bool {
+	return r.Err != nil || r.Got != r.Case.Expected
+}
+
+/* returns the number of failing cases */
+func printEval(results []EvalResult) int {
+	var failures int
+	for _, result := range results {
+		if !result.Failed() {
+			fmt.Printf("ok    %s\n", result.Case.file)
+			continue
+		}
+
+		failures++
+		yellow.Printf("FAIL  %s: %s\n", result.Case.file, result.Case.Name)
+		switch {
+		case result.Err != nil:
+			fmt.Printf("      error: %v\n", result.Err)
+		case result.Got == "":
+			fmt.Printf("      expected %s, no match\n", result.Case.Expected)
+		case result.Case.Expected == "":
+			fmt.Printf("      expected no match, got %s\n", result.Got)
+		default:
+			fmt.Printf("      expected %s, got %s\n", result.Case.Expected, result.Got)
+		}
+	}
+
+	precision, recall := evalScores(results)
+	fmt.Println()
+	boldWhite.Printf("cases: %d  failures: %d  precision: %.3f  recall: %.3f\n", len(results), failures, precision, recall)
+	return failures
+}
+
+/* goffy eval [flags] [/path/to/fixtures]: evaluates the matcher against the golden dataset */
+func runEvalCommand(args []string) error {
+	fixtures, err := fs.Sub(evalFixtures, "testdata/matching")
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("%w: usage: goffy eval [/path/to/fixtures]", errInvalidInput)
+	}
+	if len(args) > 0 {
+		fixtures = os.DirFS(args[0])
+	}
+
+	cases, err := LoadEvalCases(fixtures)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidInput, err)
+	}
+
+	if failures := printEval(RunEval(cases)); failures > 0 {
+		return fmt.Errorf("%d of %d cases failed", failures, len(cases))
+	}
+
+	return nil
+}
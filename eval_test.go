@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+/* the golden dataset of goffy eval, every case has to pass */
+func TestMatchingDataset(t *testing.T) {
+	cases, err := LoadEvalCases(os.DirFS("testdata/matching"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range RunEval(cases) {
+		t.Run(result.Case.file, func(t *testing.T) {
+			if result.Err != nil {
+				t.Fatalf("%s: %v", result.Case.Name, result.Err)
+			}
+			if result.Failed() {
+				t.Errorf("%s: expected %q, got %q", result.Case.Name, result.Case.Expected, result.Got)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* tracks already in the folder are skipped, unless -overwrite */
+var (
+	overwrite    bool
+	skipExisting bool /* -skip-existing: also look for the spotify ID in the tags of every file */
+)
+
+func setupExisting() error {
+	if overwriteF && skipExistF {
+		return errors.New("-overwrite and -skip-existing can't be used together")
+	}
+
+	overwrite, skipExisting = overwriteF, skipExistF
+	return nil
+}
+
+/* spotify track ID -> file, of the audio files of the folder that have one in their tags */
+func taggedFiles(dir string) map[string]string {
+	files := make(map[string]string)
+	if !skipExisting {
+		return files
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return files
+	}
+
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		if id := spotifyIDTag(file); id != "" {
+			files[id] = file
+		}
+	}
+
+	return files
+}
+
+/* m4a tags are read natively, the other formats need ffprobe */
+func spotifyIDTag(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".m4a":
+		tags, err := ReadMP4Freeform(file)
+		if err != nil {
+			return ""
+		}
+		return tags["SPOTIFY_TRACK_ID"]
+	case ".mp3", ".opus", ".ogg", ".flac":
+		out, err := exec.Command("ffprobe", "-v", "quiet",
+			"-show_entries", "format_tags=spotify_track_id,SPOTIFY_TRACK_ID:stream_tags=spotify_track_id,SPOTIFY_TRACK_ID",
+			"-of", "default=noprint_wrappers=1:nokey=1", file).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	}
+
+	return ""
+}
+
+/* the file of the track if it was already downloaded, by its name or (-skip-existing) by its tags */
+func existingFile(track Track, dir string, tagged map[string]string) string {
+	if overwrite {
+		return ""
+	}
+
+	title, artist := correctFilename(track.Title, track.Artist)
+	file := filepath.Join(dir, fmt.Sprintf("%s - %s.%s", title, artist, outputFormat.Ext))
+	if size, err := GetFileSize(file); err == nil && size > 0 {
+		return file
+	}
+
+	return tagged[track.ID]
+}
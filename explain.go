@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/* progress messages and errors, on stderr with -explain-format json so stdout only has the report */
+var logOutput io.Writer = os.Stdout
+
+/* -explain output, durations are in seconds so reports can be diffed easily */
+type explainTrack struct {
+	Title    string          `json:"title"`
+	Artist   string          `json:"artist"`
+	Album    string          `json:"album"`
+	Duration float64         `json:"duration"`
+	ISRC     string          `json:"isrc,omitempty"`
+	Searches []explainSearch `json:"searches"`
+	Winner   string          `json:"winner"`
+	Path     MatchPath       `json:"path,omitempty"`
+	Skipped  bool            `json:"skipped,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type explainSearch struct {
+	Query      string             `json:"query"`
+	Page       int                `json:"page"`
+	Candidates []explainCandidate `json:"candidates"`
+}
+
+type explainCandidate struct {
+	Id       string  `json:"id"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Duration float64 `json:"duration"`
+	Scores   Ratio   `json:"scores"`
+	Partial  bool    `json:"partialMatch"`
+	InRange  bool    `json:"durationMatch"`
+	Blocked  bool    `json:"blockedVersion"`
+}
+
+/* prints the candidates considered for each track and the chosen one, without downloading anything */
+func Explain(tracks []Track, format string) error {
+	explained := []explainTrack{} /* [] rather than null when there is nothing to explain */
+
+	for _, track := range tracks {
+		report, err := MatchTrack(track)
+		if err != nil {
+			report = &MatchReport{Track: track}
+		}
+
+		explained = append(explained, newExplainTrack(report, err))
+		if format == "text" {
+			printExplainTrack(explained[len(explained)-1])
+		}
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(explained, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+
+	return nil
+}
+
+func newExplainTrack(report *MatchReport, err error) explainTrack {
+	explained := explainTrack{
+		Title:    report.Track.Title,
+		Artist:   report.Track.Artist,
+		Album:    report.Track.Album,
+		Duration: report.Track.Duration.Seconds(),
+		ISRC:     report.Track.ISRC,
+		Searches: []explainSearch{},
+		Winner:   report.Id,
+		Path:     report.Path,
+		Skipped:  report.Skipped,
+	}
+
+	if err != nil {
+		explained.Error = err.Error()
+	}
+
+	for _, search := range report.Searches {
+		s := explainSearch{Query: search.Query, Page: search.Page, Candidates: []explainCandidate{}}
+		for _, c := range search.Candidates {
+			s.Candidates = append(s.Candidates, explainCandidate{
+				Id:       c.Result.Id,
+				Title:    c.Result.Title,
+				Artist:   c.Result.Artist,
+				Album:    c.Result.Album,
+				Duration: c.Result.Duration.Seconds(),
+				Scores:   c.Ratio,
+				Partial:  c.Partial,
+				InRange:  c.InRange,
+				Blocked:  c.Blocked,
+			})
+		}
+		explained.Searches = append(explained.Searches, s)
+	}
+
+	return explained
+}
+
+func printExplainTrack(t explainTrack) {
+	boldWhite.Printf("'%s' by '%s' (%s, %s)\n", t.Title, t.Artist, t.Album, formatSeconds(t.Duration))
+
+	for _, search := range t.Searches {
+		fmt.Printf("  query: %s (page %d)\n", search.Query, search.Page)
+		for _, c := range search.Candidates {
+			mark := map[bool]string{true: "*", false: " "}[c.Id == t.Winner]
+			fmt.Printf("   %s %s  total %.3f | title %.3f  artist %.3f  album %.3f  duration %.3f  version %.3f | partial %s  duration %s  blocked %s\n",
+				mark, c.Id, c.Scores.Total, c.Scores.Title, c.Scores.Artist, c.Scores.Album, c.Scores.Duration, c.Scores.Version,
+				yesNo(c.Partial), yesNo(c.InRange), yesNo(c.Blocked))
+			fmt.Printf("       '%s' by %s (%s, %s)\n", c.Title, c.Artist, c.Album, formatSeconds(c.Duration))
+		}
+	}
+
+	switch {
+	case t.Error != "":
+		yellow.Printf("  error: %s\n\n", t.Error)
+	case t.Skipped:
+		fmt.Printf("  skipped (%s)\n\n", t.Path)
+	case t.Winner == "":
+		yellow.Printf("  no match\n\n")
+	default:
+		fmt.Printf("  winner: %s (%s)\n\n", t.Winner, t.Path)
+	}
+}
+
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func yesNo(b bool) string {
+	return map[bool]string{true: "yes", false: "no"}[b]
+}
+
+/* -explain: resolves the tracks of the given option and explains how each one is matched */
+func runExplain(format string) error {
+	if format != "text" && format != "json" {
+		return errors.New("invalid explain format (text or json)")
+	}
+
+	if format == "json" {
+		logOutput = os.Stderr
+	}
+
+	/* a cached match has no candidates to show, every track is searched again */
+	refreshMatches = true
+
+	tracks, err := explainTracks()
+	if err != nil {
+		return err
+	}
+
+	return Explain(tracks, format)
+}
+
+func explainTracks() ([]Track, error) {
+	switch {
+	case resourceFlag() != "":
+		return ResourceTracks(resourceFlag())
+	case fileF != "":
+		return processTxt(fileF)
+	}
+
+	return nil, errors.New("nothing to explain, use -t, -p, -a or -f")
+}
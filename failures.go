@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* stages of dlTrack a track can fail at */
+const (
+	StageMatch    = "match"
+	StageDownload = "download"
+	StageConvert  = "convert"
+	StageTag      = "tag"
+)
+
+const failuresFile = "goffy-failures" /* .json or .csv, in the music folder */
+
+/* -failures-format: json or csv */
+var failuresFormat = "json"
+
+type Failure struct {
+	SpotifyID string    `json:"spotifyId"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	Stage     string    `json:"stage"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+var failuresHeader = []string{"spotify_id", "title", "artist", "album", "stage", "error", "time"}
+
+/* the failures of a dlTrack call, and the tracks that didn't fail this time */
+type failureLog struct {
+	entries []Failure
+	done    map[string]bool
+}
+
+func (f *failureLog) Add(track Track, stage string, err error) {
+	reason := "no match found"
+	if err != nil {
+		reason = err.Error()
+	}
+
+	f.entries = append(f.entries, Failure{
+		SpotifyID: track.ID,
+		Title:     track.Title,
+		Artist:    track.Artist,
+		Album:     track.Album,
+		Stage:     stage,
+		Error:     reason,
+		Time:      time.Now(),
+	})
+}
+
+/* downloaded, already existing or skipped on purpose, so no longer a failure */
+func (f *failureLog) Done(track Track) {
+	if f.done == nil {
+		f.done = make(map[string]bool)
+	}
+	f.done[track.ID] = true
+}
+
+func failuresOf(result *DownloadResult) *failureLog {
+	failures := &failureLog{}
+	for _, track := range result.Tracks {
+		if track.Outcome == OutcomeFailed {
+			failures.Add(track.Track, track.Stage, track.Err)
+		} else {
+			failures.Done(track.Track)
+		}
+	}
+
+	return failures
+}
+
+func setupFailures() error {
+	switch failuresF {
+	case "":
+	case "json", "csv":
+		failuresFormat = failuresF
+	default:
+		return fmt.Errorf("unknown failures format '%s' (json or csv)", failuresF)
+	}
+
+	return nil
+}
+
+/*
+updates goffy-failures.json (or .csv) in the music folder: the failures of previous runs are kept,
+unless the track was downloaded this time or failed again
+*/
+func (f *failureLog) Save(dir string) (string, error) {
+	file := filepath.Join(dir, failuresFile+"."+failuresFormat)
+	previous, err := LoadFailures(file)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	failed := make(map[string]bool)
+	for _, entry := range f.entries {
+		failed[entry.SpotifyID] = true
+	}
+
+	var entries []Failure
+	for _, entry := range previous {
+		if !f.done[entry.SpotifyID] && !failed[entry.SpotifyID] {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, f.entries...)
+
+	if len(entries) == 0 {
+		if len(previous) > 0 {
+			return "", os.Remove(file) /* every failure was fixed */
+		}
+		return "", nil
+	}
+
+	if err := writeFailures(file, entries); err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
+
+func writeFailures(file string, entries []Failure) error {
+	if strings.HasSuffix(file, ".csv") {
+		out, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(out)
+		w.Write(failuresHeader)
+		for _, e := range entries {
+			w.Write([]string{e.SpotifyID, e.Title, e.Artist, e.Album, e.Stage, e.Error, e.Time.Format(time.RFC3339)})
+		}
+		w.Flush()
+
+		if err := w.Error(); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+/* reads a failure report, json or csv by its extension */
+func LoadFailures(file string) ([]Failure, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Failure
+	if !strings.HasSuffix(file, ".csv") {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("error parsing '%s': %w", file, err)
+		}
+		return entries, nil
+	}
+
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %w", file, err)
+	}
+
+	for i, record := range records {
+		if i == 0 || len(record) != len(failuresHeader) {
+			continue /* header */
+		}
+
+		t, _ := time.Parse(time.RFC3339, record[6])
+		entries = append(entries, Failure{
+			SpotifyID: record[0],
+			Title:     record[1],
+			Artist:    record[2],
+			Album:     record[3],
+			Stage:     record[4],
+			Error:     record[5],
+			Time:      t,
+		})
+	}
+
+	return entries, nil
+}
+
+/* goffy retry <report>: downloads the failed tracks again, into the folder of the report */
+func runRetryCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: usage: goffy retry </path/to/goffy-failures.json> [-relaxed]", errInvalidInput)
+	}
+
+	entries, err := LoadFailures(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidInput, err)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Nothing to retry")
+		return nil
+	}
+
+	if relaxedF {
+		relaxMatcher()
+	}
+
+	/* the report is written again in the same format */
+	if strings.HasSuffix(args[0], ".csv") {
+		failuresFormat = "csv"
+	} else {
+		failuresFormat = "json"
+	}
+
+	fmt.Println("Getting tracks' info...")
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = Resource{Kind: KindTrack, ID: entry.SpotifyID}.URL()
+	}
+	tracks := lookupTracks(urls)
+
+	dir := filepath.Dir(args[0]) + string(filepath.Separator)
+	fmt.Printf("Now, retrying %d tracks...\n", len(tracks))
+	if _, err := dlTrack(tracks, dir); err != nil {
+		return err
+	}
+
+	totals.print()
+	return nil
+}
+
+/* -relaxed: more results are considered and previous matches are searched again */
+func relaxMatcher() {
+	searchCandidates = max(searchCandidates, 5)
+	searchPages = max(searchPages, 4)
+	refreshMatches = true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* an output format, the audio is downloaded as m4a (AAC) or webm (opus) depending on -quality, and converted to it by ffmpeg */
+type audioFormat struct {
+	Ext     string
+	Codec   []string /* ffmpeg arguments, none for m4a */
+	Bitrate bool     /* whether -bitrate applies */
+	Cover   bool     /* whether the cover can be embedded by ffmpeg */
+}
+
+var audioFormats = map[string]audioFormat{
+	"m4a":  {Ext: "m4a"}, /* as downloaded, tagged natively */
+	"mp3":  {Ext: "mp3", Codec: []string{"-c:a", "libmp3lame", "-id3v2_version", "3"}, Bitrate: true, Cover: true},
+	"opus": {Ext: "opus", Codec: []string{"-c:a", "libopus"}, Bitrate: true},
+	"ogg":  {Ext: "ogg", Codec: []string{"-c:a", "libvorbis"}, Bitrate: true},
+	"flac": {Ext: "flac", Codec: []string{"-c:a", "flac"}, Cover: true},
+}
+
+var (
+	outputFormat = audioFormats["m4a"]
+	bitrate      = "192k"
+)
+
+var bitratePattern = regexp.MustCompile(`^[1-9][0-9]*k$`)
+
+/* validates -format and -bitrate */
+func setupFormat() error {
+	if formatF != "" {
+		format, ok := audioFormats[strings.ToLower(formatF)]
+		if !ok {
+			return fmt.Errorf("unknown format '%s' (m4a, mp3, opus, ogg or flac)", formatF)
+		}
+		outputFormat = format
+	}
+
+	if outputFormat.Codec != nil {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("-format %s needs ffmpeg", outputFormat.Ext)
+		}
+	}
+
+	if bitrateF != "" {
+		if !bitratePattern.MatchString(bitrateF) {
+			return fmt.Errorf("invalid bitrate '%s' (e.g. 128k, 192k, 320k)", bitrateF)
+		}
+		bitrate = bitrateF
+	}
+
+	return nil
+}
+
+/* "/path/to/title - artist.m4a" -> "/path/to/title - artist.mp3" */
+func outputPath(src string) string {
+	return strings.TrimSuffix(src, filepath.Ext(src)) + "." + outputFormat.Ext
+}
+
+/* transcodes the downloaded audio (m4a or webm) to -format, tags included; the download is removed */
+func convertAudio(src string, track Track) (string, error) {
+	dst := outputPath(src)
+	args := []string{"-y", "-i", src}
+
+	if outputFormat.Cover {
+		cover, err := coverTempFile(track)
+		if err != nil {
+			yellow.Printf("Error getting the cover of '%s': %v\n", track.Title, err)
+		}
+		if cover != "" {
+			defer os.Remove(cover)
+			args = append(args, "-i", cover, "-map", "0:a", "-map", "1", "-c:v", "copy", "-disposition:v", "attached_pic")
+		}
+	}
+	if len(args) == 3 {
+		args = append(args, "-map", "0:a")
+	}
+
+	/* opus (-quality opus) to opus is just a change of container */
+	if outputFormat.Ext == "opus" && filepath.Ext(src) == ".webm" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, outputFormat.Codec...)
+		if outputFormat.Bitrate {
+			args = append(args, "-b:a", bitrate)
+		}
+	}
+
+	/* unlike mp4, ID3 and vorbis comments take any key */
+	for _, tag := range append(trackTags(track), extraTags(track)...) {
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", tag[0], tag[1]))
+	}
+	args = append(args, dst)
+
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return "", fmt.Errorf("error converting to %s: %w (%s)", outputFormat.Ext, err, lastLine(string(out)))
+	}
+
+	return dst, os.Remove(src)
+}
+
+func extraTags(track Track) [][2]string {
+	var tags [][2]string
+	for _, tag := range [][2]string{{"isrc", track.ISRC}, {"spotify_track_id", track.ID}, {"spotify_album_id", track.AlbumID}} {
+		if tag[1] != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+/* ffmpeg prints the reason of a failure last */
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
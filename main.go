@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	    
 	"github.com/fatih/color"
 )
@@ -15,29 +16,132 @@ var (
 )
 
 var (
-	trackF    string
-	playlistF string
-	albumF    string
-	fileF     string
-	desktopF  string
-	mobileF   bool
+	trackF      string
+	playlistF   string
+	albumF      string
+	artistF     string
+	fileF       string
+	desktopF    string
+	mobileF     bool
+	configF     string
+	matcherF    string
+	weightsF    string
+	candidatesF int
+	pagesF      int
+	explainF    bool
+	explainFmtF string
+	blockF      string
+	overridesF  string
+	interactF   bool
+	ambiguousF  float64
+	releasesF   string
+	coverSizeF  string
+	coverFileF  bool
+	formatF     string
+	bitrateF    string
+	convertF    int
+	lookupF     int
+	searchF     int
+	downloadF   int
+	rpsF        float64
+	qualityF    string
+	overwriteF  bool
+	skipExistF  bool
+	pruneF      bool
+	archiveF    string
+	failuresF   string
+	relaxedF    bool
 )
 
+var commands = []string{
+	"eval [/path/to/fixtures]	Evaluate the matcher offline against recorded searches (default: the built-in dataset)",
+	"sync <url> </path/to/folder/>	Download what was added to a playlist since the last sync (-prune or -archive what was removed)",
+	"retry </path/to/goffy-failures.json>	Download again the tracks of a failure report (-relaxed to consider more results)",
+	"override <track> <video>	Always use a YouTube video (or 'skip') for a Spotify track URL/ID or \"title - artist\"",
+}
+
+/* splits "goffy <command> [flags] [args]" from the regular "goffy [flags]" */
+func parseCommand(args []string) (string, []string) {
+	if len(args) > 0 {
+		for _, c := range commands {
+			if name, _, _ := strings.Cut(c, " "); name == args[0] {
+				return name, args[1:]
+			}
+		}
+	}
+
+	return "", args
+}
+
+/* flags may come after the positional arguments: goffy <url> <url> -d /path */
+func parseInterleaved(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func main() {
 	flag.StringVar(&trackF, "t", "", "Download a single track. Usage: -t URL")
 	flag.StringVar(&playlistF, "p", "", "Download an entire playlist. Usage: -p URL")
 	flag.StringVar(&albumF, "a", "", "Download an album. Usage: -a URL")
+	flag.StringVar(&artistF, "artist", "", "Download the discography of an artist, one folder per release. Usage: -artist URL")
+	flag.StringVar(&releasesF, "release-types", "", "Releases of -artist downloaded: album, single, compilation and/or appears_on (default album,single,compilation). Usage: -release-types album,single")
+	flag.StringVar(&releasedAfter, "released-after", "", "Only releases of -artist from this date on. Usage: -released-after 2015 (or 2015-06, 2015-06-21)")
+	flag.StringVar(&releasedBefore, "released-before", "", "Only releases of -artist up to this date. Usage: -released-before 2020-12-31")
 	flag.StringVar(&fileF, "f", "", "Download multiple tracks from a txt file. Usage: -f /PATH/TO/TXT")
 	flag.StringVar(&desktopF, "d", "", "Specify the path to save the music locally. Usage: -d /PATH/TO/MUSIC/FOLDER/")
 	flag.BoolVar(&mobileF, "m", false, "Save music on your mobile device. Don't have to specify any path. Usage: -m")
+	flag.StringVar(&configF, "config", "", "Path to a JSON config file (default: goffy/config.json in your user config dir). Usage: -config /PATH/TO/CONFIG")
+	flag.StringVar(&matcherF, "matcher", "", "Strategy used to match YouTube Music results: levenshtein, jarowinkler or tokenset. Usage: -matcher jarowinkler")
+	flag.StringVar(&weightsF, "weights", "", "Weights of each matching score. Usage: -weights title=1,artist=1,album=1,duration=1.5")
+	flag.IntVar(&candidatesF, "candidates", 0, "Number of YouTube Music results considered per page (default 2). Usage: -candidates 5")
+	flag.IntVar(&pagesF, "pages", 0, "Number of YouTube Music result pages requested per search (default 2). Usage: -pages 1")
+	flag.StringVar(&blockF, "block", "", "Versions rejected unless the Spotify title asks for them (default live,cover,karaoke,instrumental, 'none' to allow all). Usage: -block karaoke,cover")
+	flag.StringVar(&overridesF, "overrides", "", "Path to the manual matches file (default: goffy/overrides.json in your user config dir). Usage: -overrides /PATH/TO/OVERRIDES")
+	flag.BoolVar(&interactF, "interactive", false, "Choose the video yourself when a match is ambiguous, instead of writing it to goffy-review.json. Usage: -interactive")
+	flag.Float64Var(&ambiguousF, "ambiguous", 0.7, "Matches below this ratio (0-1) are reviewed. Usage: -ambiguous 0.8")
+	flag.BoolVar(&refreshMatches, "refresh-matches", false, "Search every track again instead of reusing the matches of previous runs. Usage: -refresh-matches")
+	flag.DurationVar(&cacheTTL, "cache-ttl", cacheTTL, "How long the matches of previous runs are reused. Usage: -cache-ttl 168h")
+	flag.StringVar(&coverSizeF, "cover-size", "", "Size of the cover embedded in each file: small, medium, large or none (default large, medium with -m). Usage: -cover-size small")
+	flag.BoolVar(&coverFileF, "cover-file", false, "Also save the cover of albums as cover.jpg in their folder. Usage: -cover-file")
+	flag.StringVar(&formatF, "format", "", "Format of the files: m4a (as downloaded), mp3, opus, ogg or flac; all but m4a need ffmpeg (default m4a). Usage: -format mp3")
+	flag.StringVar(&bitrateF, "bitrate", "", "Bitrate of mp3, opus and ogg files (default 192k). Usage: -bitrate 320k")
+	flag.StringVar(&qualityF, "quality", "", "Audio downloaded from YouTube: aac (128k), opus (160k, needs -format), best, smallest or the closest to a bitrate; without -format only AAC is available (default aac). Usage: -quality 48k")
+	flag.IntVar(&lookupF, "lookup-workers", 0, "Number of Spotify track lookups (txt files and retries) run at the same time (default 8). Usage: -lookup-workers 4")
+	flag.IntVar(&searchF, "search-workers", 0, "Number of YouTube Music searches run at the same time (default 4). Usage: -search-workers 2")
+	flag.IntVar(&downloadF, "download-workers", 0, "Number of YouTube downloads run at the same time (default 4). Usage: -download-workers 8")
+	flag.IntVar(&convertF, "convert-workers", 0, "Number of files tagged or converted at the same time, apart from downloads (default: number of CPUs). Usage: -convert-workers 2")
+	flag.Float64Var(&rpsF, "rps", 10, "Requests per second to Spotify, YouTube Music and YouTube, all together (0 for no limit). Usage: -rps 5")
+	flag.BoolVar(&overwriteF, "overwrite", false, "Download and overwrite the tracks that are already in the folder, instead of skipping them. Usage: -overwrite")
+	flag.BoolVar(&skipExistF, "skip-existing", false, "Besides by file name, also skip the tracks whose Spotify ID is in the tags of a file of the folder (slower). Usage: -skip-existing")
+	flag.BoolVar(&pruneF, "prune", false, "sync: delete the files of the tracks removed from the playlist. Usage: goffy sync URL /PATH/ -prune")
+	flag.StringVar(&archiveF, "archive", "", "sync: move the files of the tracks removed from the playlist to this folder. Usage: goffy sync URL /PATH/ -archive /PATH/TO/ARCHIVE")
+	flag.IntVar(&retryPolicy.Attempts, "retries", retryPolicy.Attempts, "Attempts of each request to Spotify, YouTube Music and YouTube before giving up. Usage: -retries 6")
+	flag.StringVar(&failuresF, "failures-format", "", "Format of the report of failed tracks written to the music folder: json or csv (default json). Usage: -failures-format csv")
+	flag.BoolVar(&relaxedF, "relaxed", false, "retry: consider more results and search again tracks matched before. Usage: goffy retry /PATH/TO/REPORT -relaxed")
+	flag.BoolVar(&explainF, "explain", false, "Show the candidates considered for each track and the chosen one, without downloading. Usage: -explain -p URL")
+	flag.StringVar(&explainFmtF, "explain-format", "text", "Output of -explain: text or json. Usage: -explain-format json")
 
     flag.Usage = func() {
     		fmt.Print("Usage: ")
     		boldWhite.Println("goffy [option] [url] [platform] [/path/to/music/folder/]")
+    		boldWhite.Println("goffy [url] [url...] [platform] [/path/to/music/folder/]")
 
     		fmt.Println("If [option] is -f, [url] is /path/to/txt")
     		fmt.Println("If [platform] is -m, [path] is omitted.")
 
+    		fmt.Printf("\nCommands:\n")
+    		for _, c := range commands {
+    			fmt.Printf("  %s\n", c)
+    		}
+
     		fmt.Printf("\nOptions:\n")
     		flag.VisitAll(func(f *flag.Flag) {
     			if f.Name != "d" && f.Name != "m" {
@@ -51,8 +155,96 @@ func main() {
     			}
     		})
     	}
-	flag.Parse()
-	
+	command, args := parseCommand(os.Args[1:])
+	args = parseInterleaved(args)
+
+	conf, err := LoadConfig(configF)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupMatcher(conf); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+	setupOverrides(conf)
+
+	if err := setupDiscography(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupCovers(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupFormat(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupQuality(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupPipeline(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupExisting(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if err := setupFailures(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitInvalidInput)
+	}
+
+	if retryPolicy.Attempts < 1 {
+		fmt.Println("-retries must be at least 1")
+		os.Exit(exitInvalidInput)
+	}
+
+	switch command {
+	case "eval":
+		err := runEvalCommand(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(commandExitCode(err))
+	case "sync":
+		err := runSyncCommand(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(totals.exitCode(err))
+	case "retry":
+		err := runRetryCommand(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(totals.exitCode(err))
+	case "override":
+		err := runOverrideCommand(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Exit(commandExitCode(err))
+	}
+
+	if explainF {
+		if err := runExplain(explainFmtF); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	tempDir := filepath.Join(GetCurrentDir(), "YourMusic")
 	zipFile := filepath.Join(GetCurrentDir(), "YourMusic.zip")
 	InterruptHandler(tempDir)
@@ -61,25 +253,41 @@ func main() {
 	ddl := DesktopDownloader{}
 	mdl := MobileDownloader{}
 
+	/* goffy <url> [<url>...]: the kind of each link is detected, -t, -p, -a and -artist are just one more link */
+	urls := args
+	if url := resourceFlag(); url != "" {
+		urls = append([]string{url}, urls...)
+	}
+
+	if desktopF != "" && !isPathValid(desktopF) {
+		fmt.Printf("'%s' is not a folder\n", desktopF)
+		os.Exit(exitInvalidInput)
+	}
+
 	switch {
-	case trackF != "" && desktopF != "":
-		ddl.Track(trackF, desktopF)
-	case playlistF != "" && desktopF != "":
-		ddl.Playlist(playlistF, desktopF)
-	case albumF != "" && desktopF != "":
-		ddl.Album(albumF, desktopF)
+	case len(urls) > 0 && desktopF != "":
+		err = ddl.Resources(urls, desktopF)
 	case fileF != "" && desktopF != "":
-		ddl.FromTxt(fileF, desktopF)
-	case trackF != "" && mobileF:
-		mdl.Track(trackF)
-	case playlistF != "" && mobileF:
-		mdl.Playlist(playlistF)
-	case albumF != "" && mobileF:
-		mdl.Album(albumF)
+		err = ddl.FromTxt(fileF, desktopF)
+	case len(urls) > 0 && mobileF:
+		err = mdl.Resources(urls)
 	case fileF != "" && mobileF:
-		mdl.FromTxt(fileF)
+		err = mdl.FromTxt(fileF)
 	default:
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitInvalidInput)
 	}
+
+	os.Exit(totals.exitCode(err))
+}
+
+/* the link given to -t, -p, -a or -artist */
+func resourceFlag() string {
+	for _, url := range []string{trackF, playlistF, albumF, artistF} {
+		if url != "" {
+			return url
+		}
+	}
+
+	return ""
 }
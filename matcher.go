@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adrg/strutil"
+	"github.com/adrg/strutil/metrics"
+)
+
+/* how much each sub-score counts towards the total ratio of a candidate */
+type Weights struct {
+	Title    float64 `json:"title"`
+	Artist   float64 `json:"artist"`
+	Album    float64 `json:"album"`
+	Duration float64 `json:"duration"`
+}
+
+/* a YouTube Music result scored against a Spotify track */
+type Candidate struct {
+	Result  YTResult
+	Ratio   Ratio
+	Partial bool /* passed isPartialMatch */
+	InRange bool /* passed isDurationMatch */
+	Blocked bool /* a blocked version (live, karaoke...) the Spotify track didn't ask for */
+}
+
+/* ranks the YouTube Music results of a Spotify track, best candidate first */
+type Matcher interface {
+	Rank(spTrack *Track, results []YTResult) []Candidate
+}
+
+/* the default matcher: weighted string similarities plus the duration difference */
+type SimilarityMatcher struct {
+	Metric  strutil.StringMetric
+	Weights Weights
+	Blocked []string /* version qualifiers rejected unless the Spotify title has them */
+}
+
+var defaultWeights = Weights{Title: 1, Artist: 1, Album: 1, Duration: 1.5}
+
+/* used by Match, replaced by setupMatcher according to the config file and flags */
+var matcher Matcher = NewMatcher("levenshtein", defaultWeights, defaultBlockedQualifiers)
+
+func NewMatcher(strategy string, weights Weights, blocked []string) Matcher {
+	var metric strutil.StringMetric
+	switch strategy {
+	case "jarowinkler":
+		metric = metrics.NewJaroWinkler()
+	case "tokenset":
+		metric = tokenSet{}
+	default:
+		metric = metrics.NewLevenshtein()
+	}
+
+	return SimilarityMatcher{Metric: metric, Weights: weights, Blocked: blocked}
+}
+
+func (m SimilarityMatcher) Rank(spTrack *Track, results []YTResult) []Candidate {
+	track := spTrack.buildTrack()
+	track.Title = RemoveAccents(strings.ToLower(track.Title))
+	track.Artist = RemoveAccents(strings.ToLower(track.Artist))
+	track.Album = RemoveAccents(strings.ToLower(track.Album))
+
+	var candidates []Candidate
+	for _, result := range results {
+		candidates = append(candidates, Candidate{
+			Result:  result,
+			Ratio:   calculateMatchRatio(track, result, m.Metric, m.Weights),
+			Partial: isPartialMatch(result, track),
+			InRange: isDurationMatch(result, track),
+			Blocked: isBlockedVersion(result.Title, track.Title, m.Blocked),
+		})
+	}
+
+	sortCandidates(candidates)
+	return candidates
+}
+
+/* valid candidates first, then by ratio; ties keep the YouTube Music order */
+func sortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Valid() != candidates[j].Valid() {
+			return candidates[i].Valid()
+		}
+		return candidates[i].Ratio.Total > candidates[j].Ratio.Total
+	})
+}
+
+func (c Candidate) Valid() bool {
+	return c.Ratio.Total > 0 && c.Partial && c.InRange && !c.Blocked
+}
+
+/* order-insensitive similarity between the sets of words of both strings */
+type tokenSet struct{}
+
+func (tokenSet) Compare(a, b string) float64 {
+	tokensA, tokensB := strings.Fields(a), strings.Fields(b)
+	if len(tokensA) == 0 || len(tokensB) == 0 {
+		return 0
+	}
+
+	set := make(map[string]bool)
+	for _, token := range tokensA {
+		set[token] = true
+	}
+
+	var common int
+	seen := make(map[string]bool)
+	for _, token := range tokensB {
+		if set[token] && !seen[token] {
+			common++
+		}
+		seen[token] = true
+	}
+
+	return float64(common) / math.Max(float64(len(set)), float64(len(seen)))
+}
+
+/* parses "title=2,artist=1,album=0.5,duration=3" on top of the given weights */
+func ParseWeights(s string, weights Weights) (Weights, error) {
+	for _, pair := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return weights, fmt.Errorf("invalid weight '%s'", pair)
+		}
+
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid weight '%s'", pair)
+		}
+
+		switch key {
+		case "title":
+			weights.Title = weight
+		case "artist":
+			weights.Artist = weight
+		case "album":
+			weights.Album = weight
+		case "duration":
+			weights.Duration = weight
+		default:
+			return weights, fmt.Errorf("unknown weight '%s'", key)
+		}
+	}
+
+	return weights, nil
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+iTunes-style metadata of MP4/M4A files, written without ffmpeg.
+the tags live in moov/udta/meta/ilst, one atom per tag:
+
+	moov
+	  udta
+	    meta (version, flags)
+	      hdlr (mdir)
+	      ilst
+	        ©nam
+	          data (type, locale, value)
+*/
+type MP4Tags struct {
+	Title, Artist, AlbumArtist, Album string
+	Date, Genre, Comment, Lyrics      string
+	Track, TrackTotal                 int
+	Disc, DiscTotal                   int
+	Explicit                          bool
+	Cover                             []byte            /* jpeg or png */
+	Freeform                          map[string]string /* ----:com.apple.iTunes:<name> atoms */
+}
+
+/* type indicators of the data atoms */
+const (
+	dataBinary  = 0
+	dataUTF8    = 1
+	dataJPEG    = 13
+	dataPNG     = 14
+	dataInteger = 21
+)
+
+const (
+	freeformMean = "com.apple.iTunes"
+	tagsPadding  = 2048 /* free space left after moov, so the next edit is done in place */
+)
+
+var errUnsupportedMP4 = errors.New("unsupported mp4 layout")
+
+/* an atom (box) in memory, payload without its header */
+type atom struct {
+	typ  string
+	data []byte
+}
+
+/* an atom in the file */
+type fileAtom struct {
+	typ          string
+	offset, size int64
+}
+
+func mp4TagsOf(track Track, cover []byte) MP4Tags {
+	tags := MP4Tags{
+		Title:       track.Title,
+		Artist:      strings.Join(track.Artists, ", "),
+		AlbumArtist: track.AlbumArtist,
+		Album:       track.Album,
+		Date:        track.ReleaseDate,
+		Comment:     tagComment(track),
+		Track:       track.TrackNumber,
+		TrackTotal:  track.TrackTotal,
+		Disc:        track.DiscNumber,
+		DiscTotal:   track.DiscTotal,
+		Explicit:    track.Explicit,
+		Cover:       cover,
+		Freeform:    make(map[string]string),
+	}
+
+	for name, value := range map[string]string{"ISRC": track.ISRC, "SPOTIFY_TRACK_ID": track.ID, "SPOTIFY_ALBUM_ID": track.AlbumID} {
+		if value != "" {
+			tags.Freeform[name] = value
+		}
+	}
+
+	return tags
+}
+
+/* replaces the tags of the file, in place when the new moov fits where the old one was */
+func WriteMP4Tags(path string, tags MP4Tags) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	atoms, err := readFileAtoms(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	moovIndex := -1
+	for i, a := range atoms {
+		if a.typ == "moov" {
+			moovIndex = i
+		}
+	}
+	if moovIndex == -1 {
+		file.Close()
+		return errors.New("no moov atom")
+	}
+
+	moov := atoms[moovIndex]
+	moovData, err := readAtomData(file, moov)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	children, err := parseAtoms(moovData)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	children, err = setIlst(children, tags)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	newMoov := encodeAtom("moov", encodeAtoms(children))
+
+	/* the old moov and the free atoms after it */
+	room, next := moov.size, moovIndex+1
+	for ; next < len(atoms) && (atoms[next].typ == "free" || atoms[next].typ == "skip"); next++ {
+		room += atoms[next].size
+	}
+
+	newSize := int64(len(newMoov))
+	switch {
+	case next == len(atoms): /* moov at the end, nothing after it moves */
+		_, err = file.WriteAt(newMoov, moov.offset)
+		if err == nil {
+			err = file.Truncate(moov.offset + newSize)
+		}
+	case newSize == room || newSize+8 <= room:
+		if newSize < room {
+			newMoov = append(newMoov, freeAtom(room-newSize)...)
+		}
+		_, err = file.WriteAt(newMoov, moov.offset)
+	default:
+		file.Close()
+		return rewriteMP4(path, atoms, moovIndex, next, children)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/* the new moov is bigger than its room: everything after it moves, and so do the chunk offsets */
+func rewriteMP4(path string, atoms []fileAtom, moovIndex, next int, children []atom) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	moov := atoms[moovIndex]
+	newMoov := encodeAtom("moov", encodeAtoms(children))
+	newEnd := moov.offset + int64(len(newMoov)) + tagsPadding
+	oldEnd := atoms[next-1].offset + atoms[next-1].size
+	delta := newEnd - oldEnd
+
+	for _, a := range atoms[next:] {
+		if a.typ == "mfra" {
+			return errUnsupportedMP4 /* absolute offsets of the fragments */
+		}
+		if a.typ == "moof" {
+			data, err := readAtomData(src, a)
+			if err != nil {
+				return err
+			}
+			if hasBaseDataOffset(data) {
+				return errUnsupportedMP4
+			}
+		}
+	}
+
+	if err := shiftChunkOffsets(children, moov.offset, delta); err != nil {
+		return err
+	}
+	newMoov = encodeAtom("moov", encodeAtoms(children))
+
+	tempFile := path + ".tags"
+	dst, err := os.Create(tempFile)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		if _, err := io.Copy(dst, io.NewSectionReader(src, 0, moov.offset)); err != nil {
+			return err
+		}
+		if _, err := dst.Write(append(newMoov, freeAtom(tagsPadding)...)); err != nil {
+			return err
+		}
+		_, err := io.Copy(dst, io.NewSectionReader(src, oldEnd, 1<<62))
+		return err
+	}()
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	src.Close()
+	return os.Rename(tempFile, path)
+}
+
+/* top level atoms of the file */
+func readFileAtoms(file *os.File) ([]fileAtom, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var atoms []fileAtom
+	header := make([]byte, 16)
+	for offset := int64(0); offset < info.Size(); {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("invalid mp4 file: %w", err)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header))
+		switch size {
+		case 0: /* up to the end of the file */
+			size = info.Size() - offset
+		case 1: /* 64 bit size */
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("invalid mp4 file: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+
+		if size < 8 || offset+size > info.Size() {
+			return nil, errors.New("invalid mp4 file: bad atom size")
+		}
+
+		atoms = append(atoms, fileAtom{typ: string(header[4:8]), offset: offset, size: size})
+		offset += size
+	}
+
+	return atoms, nil
+}
+
+/* payload of a top level atom */
+func readAtomData(file io.ReaderAt, a fileAtom) ([]byte, error) {
+	data := make([]byte, a.size)
+	if _, err := file.ReadAt(data, a.offset); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(data) == 1 {
+		return data[16:], nil
+	}
+	return data[8:], nil
+}
+
+func parseAtoms(b []byte) ([]atom, error) {
+	var atoms []atom
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("invalid mp4 file: truncated atom")
+		}
+
+		size, headerSize := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, errors.New("invalid mp4 file: truncated atom")
+			}
+			size, headerSize = binary.BigEndian.Uint64(b[8:]), 16
+		}
+
+		if size < headerSize || size > uint64(len(b)) {
+			return nil, errors.New("invalid mp4 file: bad atom size")
+		}
+
+		atoms = append(atoms, atom{typ: string(b[4:8]), data: b[headerSize:size]})
+		b = b[size:]
+	}
+
+	return atoms, nil
+}
+
+func encodeAtom(typ string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(b, uint32(8+len(data)))
+	copy(b[4:], typ)
+	return append(b, data...)
+}
+
+func encodeAtoms(atoms []atom) []byte {
+	var b []byte
+	for _, a := range atoms {
+		b = append(b, encodeAtom(a.typ, a.data)...)
+	}
+	return b
+}
+
+func freeAtom(size int64) []byte {
+	return encodeAtom("free", make([]byte, size-8))
+}
+
+/* the child of the given type, created (with its default payload) if there is none */
+func childAtom(atoms []atom, typ string, empty []byte) ([]atom, int) {
+	for i, a := range atoms {
+		if a.typ == typ {
+			return atoms, i
+		}
+	}
+
+	return append(atoms, atom{typ: typ, data: empty}), len(atoms)
+}
+
+/* moov children with the new ilst, the other items of the old one are kept */
+func setIlst(moov []atom, tags MP4Tags) ([]atom, error) {
+	moov, u := childAtom(moov, "udta", nil)
+	udta, err := parseAtoms(moov[u].data)
+	if err != nil {
+		return nil, err
+	}
+
+	udta, m := childAtom(udta, "meta", make([]byte, 4))
+	if len(udta[m].data) < 4 {
+		return nil, errors.New("invalid mp4 file: bad meta atom")
+	}
+	version, meta := udta[m].data[:4], udta[m].data[4:]
+	metaChildren, err := parseAtoms(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	metaChildren, _ = childAtom(metaChildren, "hdlr", mdirHandler())
+	metaChildren, i := childAtom(metaChildren, "ilst", nil)
+	items, err := parseAtoms(metaChildren[i].data)
+	if err != nil {
+		return nil, err
+	}
+
+	newItems := ilstItems(tags)
+	var kept []atom
+	for _, item := range items {
+		if !replacesItem(newItems, item) {
+			kept = append(kept, item)
+		}
+	}
+
+	metaChildren[i].data = encodeAtoms(append(kept, newItems...))
+	udta[m].data = append(append([]byte{}, version...), encodeAtoms(metaChildren)...)
+	moov[u].data = encodeAtoms(udta)
+
+	return moov, nil
+}
+
+/* hdlr of the iTunes metadata: version/flags, pre-defined, "mdir", "appl", reserved, empty name */
+func mdirHandler() []byte {
+	b := make([]byte, 25)
+	copy(b[8:], "mdir")
+	copy(b[12:], "appl")
+	return b
+}
+
+func ilstItems(tags MP4Tags) []atom {
+	var items []atom
+	for _, text := range []struct{ typ, value string }{
+		{"\xa9nam", tags.Title},
+		{"\xa9ART", tags.Artist},
+		{"aART", tags.AlbumArtist},
+		{"\xa9alb", tags.Album},
+		{"\xa9day", tags.Date},
+		{"\xa9gen", tags.Genre},
+		{"\xa9cmt", tags.Comment},
+		{"\xa9lyr", tags.Lyrics},
+	} {
+		if text.value != "" {
+			items = append(items, atom{typ: text.typ, data: dataAtom(dataUTF8, []byte(text.value))})
+		}
+	}
+
+	if tags.Track > 0 {
+		items = append(items, atom{typ: "trkn", data: dataAtom(dataBinary, pairOf(tags.Track, tags.TrackTotal, 8))})
+	}
+	if tags.Disc > 0 {
+		items = append(items, atom{typ: "disk", data: dataAtom(dataBinary, pairOf(tags.Disc, tags.DiscTotal, 6))})
+	}
+	if tags.Explicit {
+		items = append(items, atom{typ: "rtng", data: dataAtom(dataInteger, []byte{1})})
+	}
+
+	if len(tags.Cover) > 0 {
+		imageType := map[bool]uint32{true: dataPNG, false: dataJPEG}[bytes.HasPrefix(tags.Cover, []byte("\x89PNG"))]
+		items = append(items, atom{typ: "covr", data: dataAtom(imageType, tags.Cover)})
+	}
+
+	var names []string
+	for name := range tags.Freeform {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := tags.Freeform[name]
+		items = append(items, atom{typ: "----", data: encodeAtoms([]atom{
+			{typ: "mean", data: append(make([]byte, 4), freeformMean...)},
+			{typ: "name", data: append(make([]byte, 4), name...)},
+			{typ: "data", data: dataPayload(dataUTF8, []byte(value))},
+		})})
+	}
+
+	return items
+}
+
+/* an item of the old ilst is dropped when the new one has the same tag */
+func replacesItem(newItems []atom, item atom) bool {
+	for _, newItem := range newItems {
+		if newItem.typ == item.typ && (item.typ != "----" || freeformName(newItem) == freeformName(item)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func freeformName(item atom) string {
+	children, _ := parseAtoms(item.data)
+	for _, child := range children {
+		if child.typ == "name" && len(child.data) >= 4 {
+			return string(child.data[4:])
+		}
+	}
+
+	return ""
+}
+
+/* data atom: type indicator, locale and value */
+func dataAtom(dataType uint32, value []byte) []byte {
+	return encodeAtom("data", dataPayload(dataType, value))
+}
+
+func dataPayload(dataType uint32, value []byte) []byte {
+	b := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(b, dataType)
+	return append(b, value...)
+}
+
+/* trkn (8 bytes) and disk (6 bytes): reserved, number, total[, reserved] */
+func pairOf(n, total, size int) []byte {
+	b := make([]byte, size)
+	binary.BigEndian.PutUint16(b[2:], uint16(n))
+	binary.BigEndian.PutUint16(b[4:], uint16(total))
+	return b
+}
+
+/* adds delta to the stco/co64 offsets that point after the moov */
+func shiftChunkOffsets(atoms []atom, after, delta int64) error {
+	for i := range atoms {
+		a := &atoms[i]
+		switch a.typ {
+		case "trak", "mdia", "minf", "stbl":
+			children, err := parseAtoms(a.data)
+			if err != nil {
+				return err
+			}
+			if err := shiftChunkOffsets(children, after, delta); err != nil {
+				return err
+			}
+			a.data = encodeAtoms(children)
+		case "stco", "co64":
+			width := map[bool]int{true: 4, false: 8}[a.typ == "stco"]
+			if len(a.data) < 8 {
+				return errors.New("invalid mp4 file: bad chunk offsets")
+			}
+
+			count := int(binary.BigEndian.Uint32(a.data[4:]))
+			if len(a.data) < 8+count*width {
+				return errors.New("invalid mp4 file: bad chunk offsets")
+			}
+
+			data := append([]byte{}, a.data...)
+			for j := 0; j < count; j++ {
+				entry := data[8+j*width:]
+				if width == 4 {
+					offset := int64(binary.BigEndian.Uint32(entry))
+					if offset > after {
+						if offset+delta > 1<<32-1 {
+							return errUnsupportedMP4 /* would need co64 */
+						}
+						binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+					}
+				} else if offset := int64(binary.BigEndian.Uint64(entry)); offset > after {
+					binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+				}
+			}
+			a.data = data
+		}
+	}
+
+	return nil
+}
+
+/* fragments whose tfhd has an absolute base data offset */
+func hasBaseDataOffset(moof []byte) bool {
+	children, _ := parseAtoms(moof)
+	for _, traf := range children {
+		if traf.typ != "traf" {
+			continue
+		}
+
+		trafChildren, _ := parseAtoms(traf.data)
+		for _, tfhd := range trafChildren {
+			if tfhd.typ == "tfhd" && len(tfhd.data) >= 4 && binary.BigEndian.Uint32(tfhd.data)&0x1 != 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/* the ----:com.apple.iTunes:<name> tags of the file, by name */
+func ReadMP4Freeform(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	atoms, err := readFileAtoms(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for _, a := range atoms {
+		if a.typ != "moov" {
+			continue
+		}
+
+		data, err := readAtomData(file, a)
+		if err != nil {
+			return nil, err
+		}
+
+		/* moov/udta/meta/ilst, meta starts with its version and flags */
+		items := childData(data, "udta", "meta")
+		if len(items) < 4 {
+			return tags, nil
+		}
+		items = childData(items[4:], "ilst")
+
+		freeform, _ := parseAtoms(items)
+		for _, item := range freeform {
+			if item.typ != "----" {
+				continue
+			}
+			if value := childData(item.data, "data"); len(value) >= 8 {
+				tags[freeformName(item)] = string(value[8:])
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+/* payload of the atom at the given path, nil if there is none */
+func childData(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		atoms, err := parseAtoms(data)
+		if err != nil {
+			return nil
+		}
+
+		data = nil
+		for _, a := range atoms {
+			if a.typ == typ {
+				data = a.data
+				break
+			}
+		}
+	}
+
+	return data
+}
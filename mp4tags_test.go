@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testChunks = [][]byte{[]byte("first chunk of audio"), []byte("second chunk of audio")}
+
+/* layouts of the synthetic files */
+const (
+	layoutMoovFirst  = iota /* ftyp, moov, mdat */
+	layoutMoovLast          /* ftyp, mdat, moov */
+	layoutFree              /* ftyp, moov, free, mdat */
+	layoutFragmented        /* ftyp, moov, moof, mdat */
+)
+
+/* a minimal m4a: one track whose chunks are testChunks, in stco (or co64) or in a fragment */
+func buildMP4(t *testing.T, layout int, co64 bool, baseDataOffset bool) string {
+	t.Helper()
+
+	ftyp := encodeAtom("ftyp", []byte("M4A \x00\x00\x02\x00isomM4A "))
+	mdatData := bytes.Join(testChunks, nil)
+	mdat := encodeAtom("mdat", mdatData)
+
+	moovWith := func(offsets []int64) []byte {
+		if layout == layoutFragmented {
+			return encodeAtom("moov", encodeAtoms([]atom{{typ: "mvex", data: encodeAtom("trex", make([]byte, 24))}}))
+		}
+
+		typ, width := "stco", 4
+		if co64 {
+			typ, width = "co64", 8
+		}
+		table := make([]byte, 8+len(offsets)*width)
+		binary.BigEndian.PutUint32(table[4:], uint32(len(offsets)))
+		for i, offset := range offsets {
+			if co64 {
+				binary.BigEndian.PutUint64(table[8+i*8:], uint64(offset))
+			} else {
+				binary.BigEndian.PutUint32(table[8+i*4:], uint32(offset))
+			}
+		}
+
+		stbl := encodeAtom("stbl", encodeAtom(typ, table))
+		trak := encodeAtom("trak", encodeAtom("mdia", encodeAtom("minf", stbl)))
+		return encodeAtom("moov", trak)
+	}
+
+	/* the size of moov doesn't depend on the offsets, so the layout is known before them */
+	moov := moovWith(make([]int64, len(testChunks)))
+	var parts [][]byte
+	switch layout {
+	case layoutMoovFirst:
+		parts = [][]byte{ftyp, moov, mdat}
+	case layoutMoovLast:
+		parts = [][]byte{ftyp, mdat, moov}
+	case layoutFree:
+		parts = [][]byte{ftyp, moov, freeAtom(4096), mdat}
+	case layoutFragmented:
+		tfhd := make([]byte, 8)
+		if baseDataOffset {
+			binary.BigEndian.PutUint32(tfhd, 0x1)
+			tfhd = append(tfhd, make([]byte, 8)...)
+		}
+		moof := encodeAtom("moof", encodeAtom("traf", encodeAtom("tfhd", tfhd)))
+		parts = [][]byte{ftyp, moov, moof, mdat}
+	}
+
+	var mdatOffset int64
+	for _, part := range parts {
+		if bytes.Equal(part, mdat) {
+			break
+		}
+		mdatOffset += int64(len(part))
+	}
+
+	offset, offsets := mdatOffset+8, []int64{}
+	for _, chunk := range testChunks {
+		offsets = append(offsets, offset)
+		offset += int64(len(chunk))
+	}
+	for i, part := range parts {
+		if bytes.Equal(part, moov) {
+			parts[i] = moovWith(offsets)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "track.m4a")
+	if err := os.WriteFile(path, bytes.Join(parts, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+/* the top level atoms of the file and the payload of moov */
+func readTestMP4(t *testing.T, path string) ([]fileAtom, []byte, *os.File) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	atoms, err := readFileAtoms(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range atoms {
+		if a.typ == "moov" {
+			data, err := readAtomData(file, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return atoms, data, file
+		}
+	}
+
+	t.Fatal("no moov atom")
+	return nil, nil, nil
+}
+
+func ilstText(moov []byte, typ string) string {
+	meta := childData(moov, "udta", "meta")
+	if len(meta) < 4 {
+		return ""
+	}
+
+	value := childData(meta[4:], "ilst", typ, "data")
+	if len(value) < 8 {
+		return ""
+	}
+	return string(value[8:])
+}
+
+/* every chunk offset still points to its audio, and so does mdat */
+func checkPayload(t *testing.T, path string, layout int, co64 bool) {
+	t.Helper()
+	atoms, moov, file := readTestMP4(t, path)
+
+	for _, a := range atoms {
+		if a.typ != "mdat" {
+			continue
+		}
+		data, err := readAtomData(file, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, bytes.Join(testChunks, nil)) {
+			t.Errorf("mdat changed: %q", data)
+		}
+	}
+
+	if layout == layoutFragmented {
+		return
+	}
+
+	typ, width := "stco", 4
+	if co64 {
+		typ, width = "co64", 8
+	}
+	table := childData(moov, "trak", "mdia", "minf", "stbl", typ)
+	if len(table) != 8+len(testChunks)*width {
+		t.Fatalf("bad %s: %d bytes", typ, len(table))
+	}
+
+	for i, chunk := range testChunks {
+		var offset int64
+		if co64 {
+			offset = int64(binary.BigEndian.Uint64(table[8+i*8:]))
+		} else {
+			offset = int64(binary.BigEndian.Uint32(table[8+i*4:]))
+		}
+
+		got := make([]byte, len(chunk))
+		if _, err := file.ReadAt(got, offset); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, chunk) {
+			t.Errorf("chunk %d at %d: got %q, want %q", i, offset, got, chunk)
+		}
+	}
+}
+
+func TestWriteMP4Tags(t *testing.T) {
+	small := MP4Tags{Title: "Song", Artist: "Band", Freeform: map[string]string{"SPOTIFY_TRACK_ID": "abc"}}
+	big := MP4Tags{
+		Title:    "Song",
+		Artist:   "Band",
+		Track:    3,
+		Explicit: true,
+		Cover:    bytes.Repeat([]byte{0xff}, 8192), /* more than the free space and the padding */
+		Freeform: map[string]string{"SPOTIFY_TRACK_ID": "abc", "ISRC": "USRC17607839"},
+	}
+
+	tests := []struct {
+		name           string
+		layout         int
+		co64           bool
+		baseDataOffset bool
+		tags           MP4Tags
+		inPlace        bool  /* mdat doesn't move */
+		err            error /* the file is left as it was */
+	}{
+		{name: "moov before mdat, grows past the padding", layout: layoutMoovFirst, tags: big},
+		{name: "moov before mdat, co64", layout: layoutMoovFirst, co64: true, tags: big},
+		{name: "moov at the end", layout: layoutMoovLast, tags: big, inPlace: true},
+		{name: "in place with free", layout: layoutFree, tags: small, inPlace: true},
+		{name: "free too small", layout: layoutFree, tags: big},
+		{name: "fragmented", layout: layoutFragmented, tags: big},
+		{name: "fragmented with base data offset", layout: layoutFragmented, baseDataOffset: true, tags: big, err: errUnsupportedMP4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := buildMP4(t, test.layout, test.co64, test.baseDataOffset)
+			before, _ := os.ReadFile(path)
+			beforeAtoms, _, _ := readTestMP4(t, path)
+
+			err := WriteMP4Tags(path, test.tags)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+				if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+					t.Error("the file was modified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkPayload(t, path, test.layout, test.co64)
+
+			atoms, moov, _ := readTestMP4(t, path)
+			if got := ilstText(moov, "\xa9nam"); got != test.tags.Title {
+				t.Errorf("title: got %q", got)
+			}
+			if got := ilstText(moov, "\xa9ART"); got != test.tags.Artist {
+				t.Errorf("artist: got %q", got)
+			}
+
+			freeform, err := ReadMP4Freeform(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.tags.Freeform {
+				if freeform[name] != value {
+					t.Errorf("%s: got %q, want %q", name, freeform[name], value)
+				}
+			}
+
+			mdatOffset := func(atoms []fileAtom) int64 {
+				for _, a := range atoms {
+					if a.typ == "mdat" {
+						return a.offset
+					}
+				}
+				return -1
+			}
+			if moved := mdatOffset(atoms) != mdatOffset(beforeAtoms); moved == test.inPlace {
+				t.Errorf("mdat moved: %v, want %v", moved, !test.inPlace)
+			}
+
+			/* tags written again fit in the padding left by the first write */
+			size := fileSize(t, path)
+			test.tags.Title = "Another song"
+			if err := WriteMP4Tags(path, test.tags); err != nil {
+				t.Fatal(err)
+			}
+			checkPayload(t, path, test.layout, test.co64)
+			if test.layout != layoutMoovLast && fileSize(t, path) != size {
+				t.Errorf("size changed from %d to %d, the padding wasn't used", size, fileSize(t, path))
+			}
+			if _, moov, _ := readTestMP4(t, path); ilstText(moov, "\xa9nam") != "Another song" {
+				t.Error("the title wasn't replaced")
+			}
+		})
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+manual matches for the tracks goffy always gets wrong, a JSON object whose keys are
+spotify track IDs or "title - artist" and whose values are YouTube video IDs or "skip":
+
+	{
+	  "4uLU6hMCjMI75M1A2tKUQC": "dQw4w9WgXcQ",
+	  "some song - some artist": "skip"
+	}
+*/
+type Overrides map[string]string
+
+const skipOverride = "skip"
+
+var (
+	overridesPath string /* set by setupOverrides */
+	overrides     Overrides
+	overridesOnce sync.Once
+)
+
+var videoIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{11}$`)
+
+/* ~/.config/goffy/overrides.json on linux */
+func defaultOverridesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "overrides.json"
+	}
+
+	return filepath.Join(dir, "goffy", "overrides.json")
+}
+
+func setupOverrides(conf *Config) {
+	overridesPath = defaultOverridesPath()
+	if conf.Overrides != "" {
+		overridesPath = conf.Overrides
+	}
+	if overridesF != "" {
+		overridesPath = overridesF
+	}
+}
+
+func LoadOverrides(path string) (Overrides, error) {
+	o := Overrides{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("error parsing overrides file '%s': %w", path, err)
+	}
+
+	/* "title - artist" keys are compared lowercased and without accents */
+	normalized := Overrides{}
+	for key, value := range o {
+		normalized[overrideKey(key)] = value
+	}
+
+	return normalized, nil
+}
+
+func (o Overrides) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+/* returns the overridden video ID (or "skip") of a track */
+func (o Overrides) Lookup(spTrack Track) (string, bool) {
+	if value, ok := o[spTrack.ID]; ok && spTrack.ID != "" {
+		return value, true
+	}
+
+	value, ok := o[overrideKey(spTrack.Title+" - "+spTrack.Artist)]
+	return value, ok
+}
+
+func overrideKey(key string) string {
+	if isSpotifyID(key) {
+		return key
+	}
+
+	return RemoveAccents(strings.ToLower(strings.TrimSpace(key)))
+}
+
+func isSpotifyID(s string) bool {
+	return spotifyIDPattern.MatchString(s)
+}
+
+/* loaded once per run, a broken file is reported and ignored */
+func lookupOverride(spTrack Track) (string, bool) {
+	overridesOnce.Do(func() {
+		var err error
+		if overrides, err = LoadOverrides(overridesPath); err != nil {
+			yellow.Fprintln(logOutput, err)
+			overrides = Overrides{}
+		}
+	})
+
+	return overrides.Lookup(spTrack)
+}
+
+/* accepts a video ID, a youtube.com/music.youtube.com/youtu.be URL or "skip" */
+func parseOverrideValue(value string) (string, error) {
+	if value == skipOverride || videoIDPattern.MatchString(value) {
+		return value, nil
+	}
+
+	u, err := url.Parse(value)
+	if err == nil {
+		id := u.Query().Get("v")
+		if u.Host == "youtu.be" {
+			id = strings.TrimPrefix(u.Path, "/")
+		}
+		if videoIDPattern.MatchString(id) {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid video '%s' (use a video ID, a YouTube URL or 'skip')", value)
+}
+
+/* accepts a spotify track URL/URI, a spotify track ID or "title - artist" */
+func parseOverrideKey(key string) (string, error) {
+	if resource, err := parseResourceOf(key, KindTrack); err == nil {
+		return resource.ID, nil
+	}
+
+	if !isSpotifyID(key) && !strings.Contains(key, " - ") {
+		return "", fmt.Errorf("invalid track '%s' (use a Spotify track URL or ID, or 'title - artist')", key)
+	}
+
+	return overrideKey(key), nil
+}
+
+/* goffy override <track> <video|skip>: stores a manual match after reviewing a bad download */
+func runOverrideCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(`%w: usage: goffy override <spotify track url | "title - artist"> <video id | youtube url | skip>`, errInvalidInput)
+	}
+
+	key, err := parseOverrideKey(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidInput, err)
+	}
+
+	value, err := parseOverrideValue(args[1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidInput, err)
+	}
+
+	o, err := LoadOverrides(overridesPath)
+	if err != nil {
+		return err
+	}
+
+	o[key] = value
+	if err := o.Save(overridesPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Override saved in %s: '%s' -> %s\n", overridesPath, key, value)
+	return nil
+}
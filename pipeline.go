@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+/*
+dlTrack is a pipeline: tracks are searched, downloaded and then tagged (or converted),
+each stage with its own workers so a slow one doesn't hold the others back
+*/
+var (
+	lookupWorkers   = 8 /* spotify track info of txt files and retries */
+	searchWorkers   = 4 /* youtube music searches */
+	downloadWorkers = 4 /* youtube streams */
+	tagWorkers      = runtime.NumCPU()
+)
+
+/* every request to spotify, youtube music and youtube waits for its turn, so goffy doesn't get throttled */
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration /* 0: no limit */
+	next     time.Time
+}
+
+var requestLimiter = &rateLimiter{}
+
+func (l *rateLimiter) SetRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+/* validates -lookup-workers, -search-workers, -download-workers, -convert-workers and -rps */
+func setupPipeline() error {
+	for _, pool := range []struct {
+		name    string
+		flag    int
+		workers *int
+	}{
+		{"-lookup-workers", lookupF, &lookupWorkers},
+		{"-search-workers", searchF, &searchWorkers},
+		{"-download-workers", downloadF, &downloadWorkers},
+		{"-convert-workers", convertF, &tagWorkers},
+	} {
+		if pool.flag < 0 {
+			return fmt.Errorf("%s must be positive", pool.name)
+		}
+		if pool.flag > 0 {
+			*pool.workers = pool.flag
+		}
+	}
+
+	if rpsF < 0 {
+		return errors.New("-rps must be positive (0 for no limit)")
+	}
+	requestLimiter.SetRate(rpsF)
+
+	return nil
+}
+
+/* starts n workers running f on what comes through in, done is called once all of them are finished */
+func runWorkers[T any](n int, in <-chan T, f func(T), done func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range in {
+				f(job)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		done()
+	}()
+}
+
+/* the track info of every link, in the same order; the ones that fail are left out and counted as failed (or invalid) links */
+func lookupTracks(urls []string) []Track {
+	tracks := make([]*Track, len(urls))
+	jobs := make(chan int)
+	finished := make(chan struct{})
+
+	runWorkers(lookupWorkers, jobs, func(i int) {
+		track, err := TrackInfo(urls[i])
+		totals.addLink(err)
+		if err != nil {
+			yellow.Fprintf(logOutput, "(URL: %s) - Error obtaining track information: %v\n", urls[i], err)
+			return
+		}
+		tracks[i] = track
+	}, func() { close(finished) })
+
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	<-finished
+
+	var found []Track
+	for _, track := range tracks {
+		if track != nil {
+			found = append(found, *track)
+		}
+	}
+
+	return found
+}
+
+/* a track going through the stages of dlTrack */
+type trackJob struct {
+	track  *Track /* built, with the defaults filled in */
+	result TrackResult
+	report *MatchReport
+	file   string
+	start  time.Time
+}
+
+func newTrackJob(track Track) *trackJob {
+	return &trackJob{track: track.buildTrack(), result: TrackResult{Track: track}}
+}
+
+/* skips the track if it already exists, otherwise looks for its video; false when the track is done */
+func (j *trackJob) search(path string, tagged map[string]string, review *reviewLog) bool {
+	j.start = time.Now()
+	track := j.track
+
+	if file := existingFile(*track, path, tagged); file != "" {
+		fmt.Printf("'%s' by '%s' already exists (%s)\n", track.Title, track.Artist, filepath.Base(file))
+		syncManifest.Put(track.ID, file, "")
+		j.result.Outcome, j.result.File = OutcomeExisting, file
+		return false
+	}
+
+	report, err := MatchTrack(*track)
+	if err == nil && isAmbiguous(report) {
+		if interactF {
+			pickCandidate(report)
+		} else {
+			review.Add(report)
+		}
+	}
+	if err == nil && report.Skipped {
+		fmt.Printf("'%s' by '%s' was skipped (%s)\n", track.Title, track.Artist, report.Path)
+		j.result.Outcome, j.result.Path = OutcomeSkipped, report.Path
+		return false
+	}
+	if err != nil || report.Id == "" {
+		yellow.Printf("Error (1): '%s' by '%s' could not be downloaded\n", track.Title, track.Artist)
+		j.result.Outcome, j.result.Stage, j.result.Err = OutcomeFailed, StageMatch, err
+		return false
+	}
+
+	j.report = report
+	j.result.VideoID, j.result.Path = report.Id, report.Path
+	return true
+}
+
+/* downloads the audio of the chosen video; false when it failed */
+func (j *trackJob) download(path string) bool {
+	track := j.track
+
+	/* the file name is corrected, the tags keep the original title */
+	title, artist := correctFilename(track.Title, track.Artist)
+	file, err := getAudio(j.report.Id, path, title, artist)
+	if err != nil {
+		fmt.Println(err)
+		yellow.Printf("Error (2): '%s' by '%s' could not be downloaded\n", track.Title, track.Artist)
+		j.result.Outcome, j.result.Stage, j.result.Err = OutcomeFailed, StageDownload, err
+		return false
+	}
+
+	/* only videos that could be downloaded are reused by the next runs */
+	if j.report.Path != PathCache && j.report.Path != PathOverride {
+		getMatchCache().Put(track.ID, j.report)
+	}
+
+	j.file = file
+	return true
+}
+
+/* tags the file, or converts it to -format */
+func (j *trackJob) tag() {
+	track := j.track
+
+	var err error
+	if outputFormat.Codec != nil {
+		if j.file, err = convertAudio(j.file, *track); err != nil {
+			fmt.Println(err)
+			yellow.Printf("Error (3): '%s' by '%s' could not be converted\n", track.Title, track.Artist)
+			j.result.Outcome, j.result.Stage, j.result.Err = OutcomeFailed, StageConvert, err
+			return
+		}
+	} else if err := addTags(j.file, *track); err != nil {
+		yellow.Println("Error adding tags: ", j.file)
+		j.result.Outcome, j.result.Stage, j.result.Err = OutcomeFailed, StageTag, err
+		return
+	}
+
+	size, _ := GetFileSize(j.file)
+	if size < 1 {
+		DeleteResource(j.file)
+	}
+
+	syncManifest.Put(track.ID, j.file, j.report.Id)
+	fmt.Printf("'%s' by '%s' was downloaded (%s)\n", j.result.Track.Title, j.result.Track.Artist, j.report.Path)
+	j.result.Outcome, j.result.File = OutcomeDownloaded, j.file
+}
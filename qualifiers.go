@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/* words that turn a track into another version of it */
+var versionQualifiers = map[string]*regexp.Regexp{
+	"live":         regexp.MustCompile(`\blive\b`),
+	"remix":        regexp.MustCompile(`\b(remix|rmx|bootleg)\b`),
+	"acoustic":     regexp.MustCompile(`\b(acoustic|unplugged)\b`),
+	"sped up":      regexp.MustCompile(`\b(sped up|speed up|nightcore)\b`),
+	"slowed":       regexp.MustCompile(`\b(slowed|reverb)\b`),
+	"karaoke":      regexp.MustCompile(`\bkaraoke\b`),
+	"instrumental": regexp.MustCompile(`\b(instrumental|off vocal)\b`),
+	"cover":        regexp.MustCompile(`\bcover\b`),
+}
+
+/* candidates with one of these (and a Spotify title without it) are rejected, the rest of mismatches are penalised */
+var defaultBlockedQualifiers = []string{"live", "cover", "karaoke", "instrumental"}
+
+/* each qualifier present on only one of the titles multiplies the total ratio by this */
+const qualifierPenalty = 0.6
+
+/* only the decorations of a title are inspected: "(...)", "[...]" and whatever follows " - " */
+var titleDecorations = regexp.MustCompile(`\(([^)]*)\)|\[([^\]]*)\]| - (.*)$`)
+
+func detectQualifiers(title string) map[string]bool {
+	found := make(map[string]bool)
+	title = RemoveAccents(strings.ToLower(title))
+
+	for _, decoration := range titleDecorations.FindAllString(title, -1) {
+		for name, pattern := range versionQualifiers {
+			if pattern.MatchString(decoration) {
+				found[name] = true
+			}
+		}
+	}
+
+	return found
+}
+
+/* qualifiers present on only one of both titles */
+func qualifierMismatches(ytTitle, spTitle string) []string {
+	ytQualifiers, spQualifiers := detectQualifiers(ytTitle), detectQualifiers(spTitle)
+
+	var mismatches []string
+	for name := range versionQualifiers {
+		if ytQualifiers[name] != spQualifiers[name] {
+			mismatches = append(mismatches, name)
+		}
+	}
+
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+/* the result is a version the Spotify track didn't ask for and the user doesn't want */
+func isBlockedVersion(ytTitle, spTitle string, blocked []string) bool {
+	ytQualifiers, spQualifiers := detectQualifiers(ytTitle), detectQualifiers(spTitle)
+
+	for _, name := range blocked {
+		if ytQualifiers[name] && !spQualifiers[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* parses "live,cover" ("none" disables blocking) */
+func ParseQualifiers(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "none" {
+		return []string{}, nil
+	}
+
+	var qualifiers []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if _, ok := versionQualifiers[name]; !ok {
+			return nil, fmt.Errorf("unknown qualifier '%s'", name)
+		}
+		qualifiers = append(qualifiers, name)
+	}
+
+	return qualifiers, nil
+}
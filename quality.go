@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+/* -quality: aac (itag 140, the default), opus (itag 251), best, smallest or a bitrate like 128k */
+var audioQuality = "aac"
+
+var (
+	qualityBitratePattern = regexp.MustCompile(`^([1-9][0-9]*)k$`)
+	errNoAudioFormat      = errors.New("the video has no audio format")
+)
+
+func setupQuality() error {
+	if qualityF == "" {
+		return nil
+	}
+
+	quality := strings.ToLower(qualityF)
+	switch {
+	case quality == "aac", quality == "opus", quality == "best", quality == "smallest":
+	case qualityBitratePattern.MatchString(quality):
+	default:
+		return fmt.Errorf("unknown quality '%s' (aac, opus, best, smallest or a bitrate like 128k)", qualityF)
+	}
+
+	if outputFormat.Codec == nil && quality == "opus" {
+		return errors.New("-quality opus needs a -format other than m4a (opus keeps the audio as it is)")
+	}
+
+	audioQuality = quality
+	return nil
+}
+
+/*
+the audio-only format closest to -quality, falling back to the next best one when the video lacks it.
+without conversion only mp4 (AAC) audio can be saved, as the files are tagged as m4a
+*/
+func pickAudioFormat(formats youtube.FormatList, quality string, mp4Only bool) (*youtube.Format, error) {
+	audio := formats.Select(func(f youtube.Format) bool {
+		return strings.HasPrefix(f.MimeType, "audio/") && (!mp4Only || strings.HasPrefix(f.MimeType, "audio/mp4"))
+	})
+
+	if len(audio) == 0 {
+		return nil, errNoAudioFormat
+	}
+
+	/* best first */
+	sort.SliceStable(audio, func(i, j int) bool {
+		return formatBitrate(audio[i]) > formatBitrate(audio[j])
+	})
+
+	switch quality {
+	case "aac", "opus":
+		codec := map[bool]string{true: "mp4a", false: "opus"}[quality == "aac"]
+		for i := range audio {
+			if strings.Contains(audio[i].MimeType, codec) {
+				return &audio[i], nil
+			}
+		}
+	case "smallest":
+		return &audio[len(audio)-1], nil
+	}
+
+	if match := qualityBitratePattern.FindStringSubmatch(quality); match != nil {
+		kbps, _ := strconv.Atoi(match[1])
+		closest := 0
+		for i := range audio {
+			if bitrateDiff(audio[i], kbps) < bitrateDiff(audio[closest], kbps) {
+				closest = i
+			}
+		}
+		return &audio[closest], nil
+	}
+
+	return &audio[0], nil /* best, or the preferred codec is missing */
+}
+
+/* the average bitrate is the real one, the bitrate is the peak */
+func formatBitrate(f youtube.Format) int {
+	if f.AverageBitrate > 0 {
+		return f.AverageBitrate
+	}
+	return f.Bitrate
+}
+
+func bitrateDiff(f youtube.Format, kbps int) float64 {
+	return math.Abs(float64(formatBitrate(f) - kbps*1000))
+}
+
+/* "audio/webm; codecs=\"opus\"" -> "webm", "audio/mp4; ..." -> "m4a" */
+func formatExt(f *youtube.Format) string {
+	if strings.HasPrefix(f.MimeType, "audio/webm") {
+		return "webm"
+	}
+	return "m4a"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type ResourceKind string
+
+const (
+	KindTrack    ResourceKind = "track"
+	KindPlaylist ResourceKind = "playlist"
+	KindAlbum    ResourceKind = "album"
+	KindArtist   ResourceKind = "artist"
+)
+
+/* what a spotify URL or URI points to */
+type Resource struct {
+	Kind ResourceKind
+	ID   string
+}
+
+var spotifyIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]{22}$`)
+
+/*
+accepts every form of spotify link:
+
+	https://open.spotify.com/track/<id>?si=...
+	https://open.spotify.com/intl-es/album/<id>
+	open.spotify.com/embed/playlist/<id>
+	spotify:track:<id>
+	spotify:user:<user>:playlist:<id> (the old form of playlist URIs)
+	https://spotify.link/<code> (resolved through its redirect)
+*/
+func ParseResource(s string) (Resource, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "spotify:") {
+		parts := strings.Split(s, ":")
+		if len(parts) == 5 && parts[1] == "user" && parts[3] == "playlist" {
+			parts = []string{parts[0], parts[3], parts[4]}
+		}
+		if len(parts) != 3 {
+			return Resource{}, fmt.Errorf("invalid spotify uri '%s'", s)
+		}
+		return newResource(parts[1], parts[2], s)
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return Resource{}, fmt.Errorf("invalid url '%s'", s)
+	}
+
+	switch u.Host {
+	case "spotify.link", "spotify.app.link":
+		resolved, err := resolveShortLink(s)
+		if err != nil {
+			return Resource{}, err
+		}
+		return ParseResource(resolved)
+	case "open.spotify.com", "play.spotify.com":
+	default:
+		return Resource{}, fmt.Errorf("not a spotify url '%s'", s)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		/* localized (/intl-xx/) and embed paths */
+		if segment != "" && !strings.HasPrefix(segment, "intl-") && segment != "embed" {
+			segments = append(segments, segment)
+		}
+	}
+
+	if len(segments) != 2 {
+		return Resource{}, fmt.Errorf("invalid spotify url '%s'", s)
+	}
+
+	return newResource(segments[0], segments[1], s)
+}
+
+func newResource(kind, id, s string) (Resource, error) {
+	switch ResourceKind(kind) {
+	case KindTrack, KindPlaylist, KindAlbum, KindArtist:
+	default:
+		return Resource{}, fmt.Errorf("unsupported spotify resource '%s'", kind)
+	}
+
+	if !spotifyIDPattern.MatchString(id) {
+		return Resource{}, fmt.Errorf("invalid spotify ID in '%s'", s)
+	}
+
+	return Resource{Kind: ResourceKind(kind), ID: id}, nil
+}
+
+/* spotify.link short links redirect to open.spotify.com */
+func resolveShortLink(link string) (string, error) {
+	var resolved string
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host == "open.spotify.com" {
+				resolved = req.URL.String()
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Get(link)
+	if err != nil {
+		return "", fmt.Errorf("error resolving '%s': %w", link, err)
+	}
+	resp.Body.Close()
+
+	if resolved == "" {
+		return "", errors.New("could not resolve short link " + link)
+	}
+
+	return resolved, nil
+}
+
+/* canonical link, short links are resolved only once */
+func (r Resource) URL() string {
+	return fmt.Sprintf("https://open.spotify.com/%s/%s", r.Kind, r.ID)
+}
+
+/* the resource must be of the expected kind ("invalid track url" otherwise) */
+func parseResourceOf(s string, kind ResourceKind) (Resource, error) {
+	resource, err := ParseResource(s)
+	if err != nil || resource.Kind != kind {
+		return Resource{}, fmt.Errorf("%w: not a spotify %s url", errInvalidInput, kind)
+	}
+
+	return resource, nil
+}
+
+/* tracks of any spotify URL, whatever its kind */
+func ResourceTracks(s string) ([]Track, error) {
+	resource, err := ParseResource(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidInput, err)
+	}
+
+	switch resource.Kind {
+	case KindPlaylist:
+		return PlaylistInfo(resource.URL())
+	case KindAlbum:
+		return AlbumInfo(resource.URL())
+	case KindArtist:
+		return ArtistInfo(resource.URL())
+	}
+
+	track, err := TrackInfo(resource.URL())
+	if err != nil {
+		return nil, err
+	}
+	return []Track{*track}, nil
+}
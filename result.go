@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/* what happened to a track */
+type Outcome string
+
+const (
+	OutcomeDownloaded Outcome = "downloaded"
+	OutcomeExisting   Outcome = "existing" /* already in the folder */
+	OutcomeSkipped    Outcome = "skipped"  /* skipped on purpose (overrides, interactive picker) */
+	OutcomeFailed     Outcome = "failed"
+)
+
+type TrackResult struct {
+	Track   Track
+	Outcome Outcome
+	Stage   string /* where it failed: match, download, convert or tag */
+	Err     error
+	File    string
+	VideoID string
+	Path    MatchPath
+	Elapsed time.Duration
+}
+
+/* returned by dlTrack */
+type DownloadResult struct {
+	Downloaded, Existing, Skipped, Failed int
+	Tracks                                []TrackResult
+	Elapsed                               time.Duration
+}
+
+func (r *DownloadResult) add(track TrackResult) {
+	switch track.Outcome {
+	case OutcomeDownloaded:
+		r.Downloaded++
+	case OutcomeExisting:
+		r.Existing++
+	case OutcomeSkipped:
+		r.Skipped++
+	case OutcomeFailed:
+		r.Failed++
+	}
+	r.Tracks = append(r.Tracks, track)
+}
+
+/* exit codes, for scripts */
+const (
+	exitOK           = 0
+	exitFailure      = 1 /* nothing could be downloaded */
+	exitInvalidInput = 2 /* invalid flags, links, paths or files (like the flag package) */
+	exitPartial      = 3 /* some tracks or links failed */
+)
+
+var errInvalidInput = errors.New("invalid input")
+
+/* totals of the whole run, several links can be downloaded at once */
+type runTotals struct {
+	mu                               sync.Mutex
+	DownloadResult                   /* every track of the run */
+	Links, FailedLinks, InvalidLinks int
+	start                            time.Time
+}
+
+var totals = runTotals{start: time.Now()}
+
+func (t *runTotals) add(result *DownloadResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, track := range result.Tracks {
+		t.DownloadResult.add(track)
+	}
+}
+
+func (t *runTotals) addLink(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Links++
+	if errors.Is(err, errInvalidInput) {
+		t.InvalidLinks++
+	} else if err != nil {
+		t.FailedLinks++
+	}
+}
+
+/* the counts of the run, then the failed tracks */
+func (t *runTotals) print() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	boldWhite.Println("\nSummary")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	if t.Links > 1 {
+		fmt.Fprintf(w, "  Links\t%d\t(%d failed, %d invalid)\n", t.Links, t.FailedLinks, t.InvalidLinks)
+	}
+	fmt.Fprintf(w, "  Downloaded\t%d\t\n", t.Downloaded)
+	fmt.Fprintf(w, "  Skipped\t%d\t(%d already existed)\n", t.Existing+t.Skipped, t.Existing)
+	fmt.Fprintf(w, "  Failed\t%d\t\n", t.Failed)
+
+	elapsed := time.Since(t.start).Round(time.Second)
+	if n := len(t.Tracks); n > 0 {
+		var tracksTime time.Duration
+		for _, track := range t.Tracks {
+			tracksTime += track.Elapsed
+		}
+		fmt.Fprintf(w, "  Time\t%s\t(%.1fs per track)\n", elapsed, tracksTime.Seconds()/float64(n))
+	}
+	w.Flush()
+
+	if t.Failed == 0 {
+		return
+	}
+
+	boldWhite.Println("\nFailed tracks")
+	fmt.Fprintln(w, "  TITLE\tARTIST\tSTAGE\tERROR")
+	for _, track := range t.Tracks {
+		if track.Outcome == OutcomeFailed {
+			reason := "no match found"
+			if track.Err != nil {
+				reason = strings.SplitN(track.Err.Error(), "\n", 2)[0]
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", track.Track.Title, track.Track.Artist, track.Stage, reason)
+		}
+	}
+	w.Flush()
+}
+
+/* the exit code of eval and override, which don't download anything */
+func commandExitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errInvalidInput):
+		return exitInvalidInput
+	}
+
+	return exitFailure
+}
+
+/* the exit code of a run, err is what the downloader returned */
+func (t *runTotals) exitCode(err error) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case errors.Is(err, errInvalidInput), t.Links > 0 && t.InvalidLinks == t.Links:
+		return exitInvalidInput
+	case err != nil && len(t.Tracks) == 0:
+		return exitFailure
+	case t.Failed == 0 && t.FailedLinks == 0 && t.InvalidLinks == 0:
+		return exitOK
+	case t.Downloaded+t.Existing+t.Skipped == 0:
+		return exitFailure
+	}
+
+	return exitPartial
+}
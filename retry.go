@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/raitonoberu/ytmusic"
+)
+
+/* how failed requests to spotify, youtube music and youtube are tried again */
+type RetryPolicy struct {
+	Attempts  int
+	Base, Max time.Duration /* delay after the first failure, doubled after each one up to Max */
+}
+
+var retryPolicy = RetryPolicy{Attempts: 4, Base: time.Second, Max: 30 * time.Second}
+
+/* a Retry-After longer than this is not waited for */
+const maxRetryAfter = 2 * time.Minute
+
+/* an error that may go away by trying again (network errors, 429 and 5xx responses) */
+type RetryableError struct {
+	Err   error
+	After time.Duration /* from Retry-After, 0 when the server doesn't say */
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+/* an error trying again won't fix */
+type PermanentError struct {
+	Reason string
+	Err    error
+}
+
+func (e *PermanentError) Error() string { return fmt.Sprintf("%s: %v", e.Reason, e.Err) }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+const (
+	ReasonPrivate       = "private video"
+	ReasonAgeRestricted = "age-restricted video"
+	ReasonRegionLocked  = "region-locked video"
+	ReasonUnavailable   = "unavailable video"
+	ReasonRejected      = "rejected request"
+	ReasonBadResponse   = "unexpected response"
+)
+
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
+
+/* runs f until it succeeds, fails with an error that isn't retryable or runs out of attempts */
+func (p RetryPolicy) Do(f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+
+		var retryable *RetryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= p.Attempts {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		if retryable.After > 0 {
+			if retryable.After > maxRetryAfter {
+				return err
+			}
+			wait = retryable.After
+		}
+		time.Sleep(wait)
+	}
+}
+
+/* exponential, with jitter so concurrent downloads don't retry all at once */
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Base << (attempt - 1)
+	if delay > p.Max || delay <= 0 {
+		delay = p.Max
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+/* non-2xx responses: 408, 429 and 5xx are retried */
+func statusError(resp *http.Response) error {
+	err := fmt.Errorf("received non-2xx status code: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &RetryableError{Err: err, After: retryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	return &PermanentError{Reason: ReasonRejected, Err: err}
+}
+
+/* "120" or an HTTP date */
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+/* errors of github.com/kkdai/youtube, a video that can't be played won't be playable in a second either */
+func classifyVideoError(err error) error {
+	var status *youtube.ErrPlayabiltyStatus
+	var code youtube.ErrUnexpectedStatusCode
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, youtube.ErrVideoPrivate):
+		return &PermanentError{Reason: ReasonPrivate, Err: err}
+	case errors.Is(err, youtube.ErrLoginRequired):
+		return &PermanentError{Reason: ReasonAgeRestricted, Err: err}
+	case errors.Is(err, youtube.ErrNotPlayableInEmbed):
+		return &PermanentError{Reason: ReasonUnavailable, Err: err}
+	case errors.As(err, &status):
+		reason := strings.ToLower(status.Reason)
+		switch {
+		case strings.Contains(reason, "country"):
+			return &PermanentError{Reason: ReasonRegionLocked, Err: err}
+		case strings.Contains(reason, "age"):
+			return &PermanentError{Reason: ReasonAgeRestricted, Err: err}
+		case strings.Contains(reason, "private"):
+			return &PermanentError{Reason: ReasonPrivate, Err: err}
+		}
+		return &PermanentError{Reason: ReasonUnavailable, Err: err}
+	case errors.As(err, &code):
+		if code == http.StatusTooManyRequests || code >= 500 {
+			return &RetryableError{Err: err}
+		}
+		return &PermanentError{Reason: ReasonRejected, Err: err}
+	}
+
+	return &RetryableError{Err: err} /* network errors */
+}
+
+/* github.com/raitonoberu/ytmusic doesn't look at the status of its responses, 408, 429 and 5xx become errors here */
+type statusTransport struct {
+	http.RoundTripper
+}
+
+func (t statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		resp.Body.Close()
+		return nil, statusError(resp)
+	}
+
+	return resp, nil
+}
+
+func init() {
+	ytmusic.HTTPClient = &http.Client{Transport: statusTransport{http.DefaultTransport}}
+}
+
+/* youtube music searches, a page that failed because of the network or a 429/5xx is requested again */
+type retryingSearch struct {
+	*ytmusic.SearchClient
+}
+
+func (s retryingSearch) Next() (*ytmusic.SearchResult, error) {
+	var result *ytmusic.SearchResult
+	err := retryPolicy.Do(func() error {
+		var err error
+		requestLimiter.Wait()
+		result, err = s.SearchClient.Next()
+		return classifySearchError(err)
+	})
+
+	return result, err
+}
+
+/*
+errors of github.com/raitonoberu/ytmusic: the ones of the transport (wrapped in *url.Error) and truncated responses are retried,
+its own "end reached" and "couldn't parse page" won't change by trying again
+*/
+func classifySearchError(err error) error {
+	var permanent *PermanentError
+	var transport *url.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &permanent), IsRetryable(err):
+		return err
+	case errors.As(err, &transport), errors.Is(err, io.ErrUnexpectedEOF):
+		return &RetryableError{Err: err}
+	}
+
+	return &PermanentError{Reason: ReasonBadResponse, Err: err}
+}
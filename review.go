@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* a search match is ambiguous below this ratio or when the runner-up is closer than ambiguousMargin */
+var ambiguousRatio = 0.7
+
+const (
+	ambiguousMargin = 0.03
+	reviewFile      = "goffy-review.json"
+	pickerResults   = 8 /* results shown after typing a custom query */
+)
+
+/* -interactive prompts are shown one at a time, even though tracks are matched concurrently */
+var (
+	promptMu sync.Mutex
+	stdin    = bufio.NewReader(os.Stdin)
+)
+
+func isAmbiguous(report *MatchReport) bool {
+	switch report.Path {
+	case PathSearch:
+	case "":
+		return len(reportCandidates(report)) > 0 /* nothing passed, but the user may still know better */
+	default:
+		return false /* overrides, ISRC hits and manual picks are trusted */
+	}
+
+	if report.Ratio < ambiguousRatio {
+		return true
+	}
+
+	for _, candidate := range reportCandidates(report) {
+		if candidate.Result.Id != report.Id && candidate.Valid() && report.Ratio-candidate.Ratio.Total < ambiguousMargin {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* every candidate of every search, once, best first */
+func reportCandidates(report *MatchReport) []Candidate {
+	seen := make(map[string]bool)
+	var candidates []Candidate
+	for _, search := range report.Searches {
+		for _, candidate := range search.Candidates {
+			if !seen[candidate.Result.Id] {
+				seen[candidate.Result.Id] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Ratio.Total > candidates[j].Ratio.Total
+	})
+
+	return candidates
+}
+
+/* -interactive: lets the user choose a candidate, skip the track or search again */
+func pickCandidate(report *MatchReport) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	candidates := reportCandidates(report)
+	for {
+		boldWhite.Printf("\n'%s' by '%s' (%s, %s) is ambiguous:\n", report.Track.Title, report.Track.Artist, report.Track.Album, formatSeconds(report.Track.Duration.Seconds()))
+		for i, c := range candidates {
+			mark := map[bool]string{true: "*", false: " "}[c.Result.Id == report.Id]
+			fmt.Printf(" %s %d) '%s' by %s (%s, %s)  ratio %.3f\n", mark, i+1, c.Result.Title, c.Result.Artist, c.Result.Album,
+				formatSeconds(c.Result.Duration.Seconds()), c.Ratio.Total)
+		}
+		fmt.Print("Number to choose, enter to keep *, 's' to skip or type a new search query: ")
+
+		input, err := stdin.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if err != nil || input == "" {
+			return
+		}
+
+		if input == "s" {
+			report.Id, report.Path, report.Skipped = "", PathManual, true
+			return
+		}
+
+		if n, err := strconv.Atoi(input); err == nil {
+			if n >= 1 && n <= len(candidates) {
+				report.Id, report.Ratio, report.Path = candidates[n-1].Result.Id, candidates[n-1].Ratio.Total, PathManual
+				return
+			}
+			yellow.Println("Invalid number")
+			continue
+		}
+
+		result, err := newTrackSearch(input).Next()
+		if err != nil {
+			yellow.Println("Error searching:", err)
+			continue
+		}
+		candidates = rankPage(&report.Track, result, pickerResults)
+	}
+}
+
+/* ambiguous matches of a non-interactive run, saved for later review */
+type ReviewEntry struct {
+	SpotifyID  string            `json:"spotifyId,omitempty"`
+	Title      string            `json:"title"`
+	Artist     string            `json:"artist"`
+	Album      string            `json:"album"`
+	Duration   float64           `json:"duration"`
+	Chosen     string            `json:"chosen"`
+	Candidates []ReviewCandidate `json:"candidates"`
+}
+
+type ReviewCandidate struct {
+	Id       string  `json:"id"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Duration float64 `json:"duration"`
+	Ratio    float64 `json:"ratio"`
+}
+
+type reviewLog struct {
+	mu      sync.Mutex
+	entries []ReviewEntry
+}
+
+func (r *reviewLog) Add(report *MatchReport) {
+	entry := ReviewEntry{
+		SpotifyID:  report.Track.ID,
+		Title:      report.Track.Title,
+		Artist:     report.Track.Artist,
+		Album:      report.Track.Album,
+		Duration:   report.Track.Duration.Seconds(),
+		Chosen:     report.Id,
+		Candidates: []ReviewCandidate{},
+	}
+
+	for _, c := range reportCandidates(report) {
+		entry.Candidates = append(entry.Candidates, ReviewCandidate{
+			Id:       c.Result.Id,
+			Title:    c.Result.Title,
+			Artist:   c.Result.Artist,
+			Album:    c.Result.Album,
+			Duration: c.Result.Duration.Seconds(),
+			Ratio:    c.Ratio.Total,
+		})
+	}
+
+	r.mu.Lock()
+	r.entries = append(r.entries, entry)
+	r.mu.Unlock()
+}
+
+/* writes goffy-review.json in the music folder, appending to the entries of previous runs */
+func (r *reviewLog) Save(dir string) (string, error) {
+	if len(r.entries) == 0 {
+		return "", nil
+	}
+
+	file := filepath.Join(dir, reviewFile)
+	var entries []ReviewEntry
+	if data, err := os.ReadFile(file); err == nil {
+		json.Unmarshal(data, &entries)
+	}
+	entries = append(entries, r.entries...)
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return file, os.WriteFile(file, append(data, '\n'), 0644)
+}
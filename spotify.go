@@ -6,7 +6,6 @@ import (
 	"io"
 	"math"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 
@@ -20,26 +19,56 @@ type ResourceEndpoint struct {
 
 type Track struct {
 	Title, Artist, Album string
+	Duration             time.Duration
+	ID                   string            /* spotify track ID */
+	ISRC                 string            /* international standard recording code, if spotify reports it */
+	ExternalIDs          map[string]string /* every external ID of the track by type (isrc, upc, ean...) */
+	ArtistIDs            []string          /* spotify IDs of every artist of the track */
+	Artists              []string          /* every artist of the track, Artist is the first one */
+	AlbumArtist          string
+	AlbumID              string
+	TrackNumber          int
+	TrackTotal           int /* tracks on the disc */
+	DiscNumber           int
+	DiscTotal            int
+	ReleaseDate          string /* yyyy-mm-dd, or just yyyy when spotify doesn't know better */
+	Explicit             bool
+	Covers               []CoverArt /* album cover in every size, largest first */
 }
 
 const (
-	tokenEndpoint       = "https://open.spotify.com/get_access_token?reason=transport&productType=web-player"
-	trackInitialPath    = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=getTrack&variables="
-	playlistInitialPath = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=fetchPlaylist&variables="
-	albumInitialPath    = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=getAlbum&variables="
-	trackEndPath        = `{"persistedQuery":{"version":1,"sha256Hash":"e101aead6d78faa11d75bec5e36385a07b2f1c4a0420932d374d89ee17c70dd6"}}`
-	playlistEndPath     = `{"persistedQuery":{"version":1,"sha256Hash":"b39f62e9b566aa849b1780927de1450f47e02c54abf1e66e513f96e849591e41"}}`
-	albumEndPath        = `{"persistedQuery":{"version":1,"sha256Hash":"46ae954ef2d2fe7732b4b2b4022157b2e18b7ea84f70591ceb164e4de1b5d5d3"}}`
+	tokenEndpoint          = "https://open.spotify.com/get_access_token?reason=transport&productType=web-player"
+	trackInitialPath       = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=getTrack&variables="
+	playlistInitialPath    = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=fetchPlaylist&variables="
+	albumInitialPath       = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=getAlbum&variables="
+	discographyInitialPath = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=queryArtistDiscographyAll&variables="
+	appearsOnInitialPath   = "https://api-partner.spotify.com/pathfinder/v1/query?operationName=queryArtistAppearsOn&variables="
+	trackEndPath           = `{"persistedQuery":{"version":1,"sha256Hash":"e101aead6d78faa11d75bec5e36385a07b2f1c4a0420932d374d89ee17c70dd6"}}`
+	playlistEndPath        = `{"persistedQuery":{"version":1,"sha256Hash":"b39f62e9b566aa849b1780927de1450f47e02c54abf1e66e513f96e849591e41"}}`
+	albumEndPath           = `{"persistedQuery":{"version":1,"sha256Hash":"46ae954ef2d2fe7732b4b2b4022157b2e18b7ea84f70591ceb164e4de1b5d5d3"}}`
+	discographyEndPath     = `{"persistedQuery":{"version":1,"sha256Hash":"9380995a9d4663cbcb5113fef3c6aabf70ae6d407ba61793fd01e2a1dd6929b0"}}`
+	appearsOnEndPath       = `{"persistedQuery":{"version":1,"sha256Hash":"9a4bb7a20d6720fe52d7b47bc001cfa91940ddf5e7113761460b4a288d18a4c1"}}`
 )
 
 func accessToken() (string, error) {
-	resp, err := http.Get(tokenEndpoint)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	var body []byte
+	err := retryPolicy.Do(func() error {
+		requestLimiter.Wait()
+		resp, err := http.Get(tokenEndpoint)
+		if err != nil {
+			return &RetryableError{Err: err}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return statusError(resp)
+		}
 
-	body, err := io.ReadAll(resp.Body)
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return &RetryableError{Err: err}
+		}
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -48,7 +77,7 @@ func accessToken() (string, error) {
 	return accessToken.String(), nil
 }
 
-/* requests to playlist/track endpoints */
+/* requests to playlist/track endpoints, 429 and 5xx responses are retried */
 func request(endpoint string) (int, string, error) {
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -61,38 +90,40 @@ func request(endpoint string) (int, string, error) {
 	}
 	req.Header.Add("Authorization", "Bearer "+bearer)
 
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		return 0, "", fmt.Errorf("error on getting response: %w", err)
-	}
-	defer resp.Body.Close()
+	var statusCode int
+	var body []byte
+	err = retryPolicy.Do(func() error {
+		requestLimiter.Wait()
+		resp, err := (&http.Client{}).Do(req)
+		if err != nil {
+			return &RetryableError{Err: fmt.Errorf("error on getting response: %w", err)}
+		}
+		defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return statusError(resp)
+		}
+
+		statusCode = resp.StatusCode
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return &RetryableError{Err: fmt.Errorf("error on reading response: %w", err)}
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, "", fmt.Errorf("error on reading response: %w", err)
+		return 0, "", err
 	}
 
-	return resp.StatusCode, string(body), nil
-}
-
-func getID(url string) string {
-	parts := strings.Split(url, "/")
-	id := strings.Split(parts[4], "?")[0]
-	return id
-}
-
-func isValidPattern(url, pattern string) bool {
-	match, _ := regexp.MatchString(pattern, url)
-	return match
+	return statusCode, string(body), nil
 }
 
 func TrackInfo(url string) (*Track, error) {
-	trackPattern := `^https:\/\/open\.spotify\.com\/track\/[a-zA-Z0-9]{22}\?si=[a-zA-Z0-9]{16}$`
-	if !isValidPattern(url, trackPattern) {
-		return nil, errors.New("invalid track url")
+	resource, err := parseResourceOf(url, KindTrack)
+	if err != nil {
+		return nil, err
 	}
 
-	id := getID(url)
+	id := resource.ID
 	endpointQuery := EncodeParam(fmt.Sprintf(`{"uri":"spotify:track:%s"}`, id))
 	endpoint := trackInitialPath + endpointQuery + "&extensions=" + EncodeParam(trackEndPath)
 
@@ -105,24 +136,38 @@ func TrackInfo(url string) (*Track, error) {
 		return nil, fmt.Errorf("received non-200 status code: %d", statusCode)
 	}
 
+	trackUnion := gjson.Get(jsonResponse, "data.trackUnion")
 	track := &Track{
-		Title:  gjson.Get(jsonResponse, "data.trackUnion.name").String(),
-		Artist: gjson.Get(jsonResponse, "data.trackUnion.firstArtist.items.0.profile.name").String(),
-		Album:  gjson.Get(jsonResponse, "data.trackUnion.albumOfTrack.name").String(),
+		Title:       trackUnion.Get("name").String(),
+		Artist:      trackUnion.Get("firstArtist.items.0.profile.name").String(),
+		Album:       trackUnion.Get("albumOfTrack.name").String(),
+		Duration:    msToDuration(trackUnion.Get("duration.totalMilliseconds").Int()),
+		ID:          id,
+		Artists:     names(trackUnion.Get("firstArtist.items.#.profile.name"), trackUnion.Get("otherArtists.items.#.profile.name")),
+		AlbumArtist: strings.Join(names(trackUnion.Get("albumOfTrack.artists.items.#.profile.name")), ", "),
+		AlbumID:     idFromURI(trackUnion.Get("albumOfTrack.uri").String()),
+		TrackNumber: int(trackUnion.Get("trackNumber").Int()),
+		DiscNumber:  int(trackUnion.Get("discNumber").Int()),
+		ReleaseDate: releaseDate(trackUnion.Get("albumOfTrack.date")),
+		Explicit:    isExplicit(trackUnion.Get("contentRating.label")),
 	}
+	track.setExternalIDs(trackUnion.Get("externalIds.items"))
+	track.setArtistIDs(trackUnion.Get("firstArtist.items.#.uri"), trackUnion.Get("otherArtists.items.#.uri"))
+	track.setTotals(trackUnion.Get("albumOfTrack.discs"), trackUnion.Get("albumOfTrack.tracks.totalCount"))
+	track.setCovers(trackUnion.Get("albumOfTrack.coverArt.sources"))
 
 	return track.buildTrack(), nil
 }
 
 func PlaylistInfo(url string) ([]Track, error) {
-	playlistPattern := `^https:\/\/open\.spotify\.com\/playlist\/[a-zA-Z0-9]{22}\?si=[a-zA-Z0-9]{16}$`
-	if !isValidPattern(url, playlistPattern) {
-		return nil, errors.New("invalid playlist url")
+	resource, err := parseResourceOf(url, KindPlaylist)
+	if err != nil {
+		return nil, err
 	}
 
 	totalCount := "data.playlistV2.content.totalCount"
 	itemsArray := "data.playlistV2.content.items"
-	tracks, err := resourceInfo(url, "playlist", totalCount, itemsArray)
+	tracks, err := resourceInfo(resource.ID, "playlist", totalCount, itemsArray)
 	if err != nil {
 		return nil, err
 	}
@@ -131,14 +176,14 @@ func PlaylistInfo(url string) ([]Track, error) {
 }
 
 func AlbumInfo(url string) ([]Track, error) {
-	albumPattern := `^https:\/\/open\.spotify\.com\/album\/[a-zA-Z0-9-]{22}\?si=[a-zA-Z0-9_-]{22}$`
-	if !isValidPattern(url, albumPattern) {
-		return nil, errors.New("invalid album url")
+	resource, err := parseResourceOf(url, KindAlbum)
+	if err != nil {
+		return nil, err
 	}
 
 	totalCount := "data.albumUnion.discs.items.0.tracks.totalCount"
 	itemsArray := "data.albumUnion.discs.items"
-	tracks, err := resourceInfo(url, "album", totalCount, itemsArray)
+	tracks, err := resourceInfo(resource.ID, "album", totalCount, itemsArray)
 	if err != nil {
 		return nil, err
 	}
@@ -147,8 +192,7 @@ func AlbumInfo(url string) ([]Track, error) {
 }
 
 /* returns playlist/album slice of tracks */
-func resourceInfo(url, resourceType, totalCount, itemList string) ([]Track, error) {
-	id := getID(url)
+func resourceInfo(id, resourceType, totalCount, itemList string) ([]Track, error) {
 	eConf := ResourceEndpoint{Limit: 400, Offset: 0}
 	jsonResponse, err := jsonList(resourceType, id, eConf.Offset, eConf.Limit)
 	if err != nil {
@@ -162,7 +206,7 @@ func resourceInfo(url, resourceType, totalCount, itemList string) ([]Track, erro
 	}
 
 	name := map[bool]string{true: gjson.Get(jsonResponse, "data.playlistV2.name").String(), false: gjson.Get(jsonResponse, "data.albumUnion.name").String()}[resourceType == "playlist"]
-	fmt.Printf("Collecting tracks from '%s'...\n", name)
+	fmt.Fprintf(logOutput, "Collecting tracks from '%s'...\n", name)
 	time.Sleep(1 * time.Second)
 
 	eConf.Requests = int64(math.Ceil(float64(eConf.TotalCount) / float64(eConf.Limit))) /* total of requests */
@@ -179,7 +223,7 @@ func resourceInfo(url, resourceType, totalCount, itemList string) ([]Track, erro
 		tracks = append(tracks, proccessItems(jsonResponse, resourceType)...)
 	}
 
-	fmt.Println("Tracks collected:", len(tracks))
+	fmt.Fprintln(logOutput, "Tracks collected:", len(tracks))
 	return tracks, nil
 }
 
@@ -209,14 +253,116 @@ func jsonList(resourceType, id string, offset, limit int64) (string, error) {
 
 func (t *Track) buildTrack() *Track {
 	track := &Track{
-		Title:  t.Title,
-		Artist: t.Artist,
-		Album:  t.Album,
+		Title:       t.Title,
+		Artist:      t.Artist,
+		Album:       t.Album,
+		Duration:    t.Duration,
+		ID:          t.ID,
+		ISRC:        t.ISRC,
+		ExternalIDs: t.ExternalIDs,
+		ArtistIDs:   t.ArtistIDs,
+		Artists:     t.Artists,
+		AlbumArtist: t.AlbumArtist,
+		AlbumID:     t.AlbumID,
+		TrackNumber: t.TrackNumber,
+		TrackTotal:  t.TrackTotal,
+		DiscNumber:  t.DiscNumber,
+		DiscTotal:   t.DiscTotal,
+		ReleaseDate: t.ReleaseDate,
+		Explicit:    t.Explicit,
+		Covers:      t.Covers,
+	}
+
+	/* album artist and artists default to the first artist */
+	if track.AlbumArtist == "" {
+		track.AlbumArtist = track.Artist
+	}
+	if len(track.Artists) == 0 && track.Artist != "" {
+		track.Artists = []string{track.Artist}
 	}
 
 	return track
 }
 
+/* items of the form {"type": "isrc", "id": "USUM71703861"} */
+func (t *Track) setExternalIDs(items gjson.Result) {
+	for _, item := range items.Array() {
+		idType, id := strings.ToLower(item.Get("type").String()), item.Get("id").String()
+		if idType == "" || id == "" {
+			continue
+		}
+
+		if t.ExternalIDs == nil {
+			t.ExternalIDs = make(map[string]string)
+		}
+		t.ExternalIDs[idType] = id
+	}
+
+	t.ISRC = strings.ToUpper(t.ExternalIDs["isrc"])
+}
+
+/* lists of "spotify:artist:<id>" */
+func (t *Track) setArtistIDs(lists ...gjson.Result) {
+	for _, list := range lists {
+		for _, uri := range list.Array() {
+			t.ArtistIDs = append(t.ArtistIDs, idFromURI(uri.String()))
+		}
+	}
+}
+
+/* the discs of an album ({"totalCount": 2, "items": [{"tracks": {"totalCount": 12}}, ...]}), or just its number of tracks */
+func (t *Track) setTotals(discs, albumTracks gjson.Result) {
+	t.DiscTotal = int(discs.Get("totalCount").Int())
+	if t.DiscNumber > 0 {
+		t.TrackTotal = int(discs.Get(fmt.Sprintf("items.%d.tracks.totalCount", t.DiscNumber-1)).Int())
+	}
+
+	if t.TrackTotal == 0 && t.DiscTotal <= 1 {
+		t.TrackTotal = int(albumTracks.Int())
+	}
+}
+
+/* several lists of names ("firstArtist" and "otherArtists") as one */
+func names(lists ...gjson.Result) []string {
+	var all []string
+	for _, list := range lists {
+		for _, name := range list.Array() {
+			if name.String() != "" {
+				all = append(all, name.String())
+			}
+		}
+	}
+
+	return all
+}
+
+/* {"isoString": "2017-06-16T00:00:00Z", "precision": "DAY", "year": 2017} -> "2017-06-16" (or just "2017") */
+func releaseDate(date gjson.Result) string {
+	iso := date.Get("isoString").String()
+	if len(iso) >= 10 && !strings.EqualFold(date.Get("precision").String(), "YEAR") {
+		return iso[:10]
+	}
+
+	if year := date.Get("year").String(); year != "" {
+		return year
+	}
+
+	if len(iso) >= 4 {
+		return iso[:4]
+	}
+
+	return ""
+}
+
+func isExplicit(label gjson.Result) bool {
+	return strings.EqualFold(label.String(), "EXPLICIT")
+}
+
+/* "spotify:track:<id>" -> "<id>" */
+func idFromURI(uri string) string {
+	return uri[strings.LastIndex(uri, ":")+1:]
+}
+
 func (eConf *ResourceEndpoint) pagination() {
 	eConf.Offset = eConf.Offset + eConf.Limit
 }
@@ -227,19 +373,48 @@ func proccessItems(jsonResponse, resourceType string) []Track {
 	songTitle := map[bool]string{true: "itemV2.data.name", false: "track.name"}[resourceType == "playlist"]
 	artistName := map[bool]string{true: "itemV2.data.artists.items.0.profile.name", false: "track.artists.items.0.profile.name"}[resourceType == "playlist"]
 	albumName := map[bool]string{true: "itemV2.data.albumOfTrack.name", false: "data.albumUnion.name"}[resourceType == "playlist"]
+	duration := map[bool]string{true: "itemV2.data.trackDuration.totalMilliseconds", false: "track.duration.totalMilliseconds"}[resourceType == "playlist"]
+	trackURI := map[bool]string{true: "itemV2.data.uri", false: "track.uri"}[resourceType == "playlist"]
+	externalIDs := map[bool]string{true: "itemV2.data.externalIds.items", false: "track.externalIds.items"}[resourceType == "playlist"]
+	artistURIs := map[bool]string{true: "itemV2.data.artists.items.#.uri", false: "track.artists.items.#.uri"}[resourceType == "playlist"]
+	artistNames := map[bool]string{true: "itemV2.data.artists.items.#.profile.name", false: "track.artists.items.#.profile.name"}[resourceType == "playlist"]
+	trackNumber := map[bool]string{true: "itemV2.data.trackNumber", false: "track.trackNumber"}[resourceType == "playlist"]
+	discNumber := map[bool]string{true: "itemV2.data.discNumber", false: "track.discNumber"}[resourceType == "playlist"]
+	contentRating := map[bool]string{true: "itemV2.data.contentRating.label", false: "track.contentRating.label"}[resourceType == "playlist"]
+	/* playlists: album of each item, albums: the album itself */
+	album := map[bool]string{true: "itemV2.data.albumOfTrack", false: "data.albumUnion"}[resourceType == "playlist"]
 
 	var tracks []Track
 	items := gjson.Get(jsonResponse, itemList).Array()
 
 	for _, item := range items {
+		albumOf := map[bool]gjson.Result{true: item.Get(album), false: gjson.Get(jsonResponse, album)}[resourceType == "playlist"]
 		track := &Track{
-			Title:  item.Get(songTitle).String(),
-			Artist: item.Get(artistName).String(),
-			Album:  map[bool]string{true: item.Get(albumName).String(), false: gjson.Get(jsonResponse, albumName).String()}[resourceType == "playlist"],
+			Title:       item.Get(songTitle).String(),
+			Artist:      item.Get(artistName).String(),
+			Album:       map[bool]string{true: item.Get(albumName).String(), false: gjson.Get(jsonResponse, albumName).String()}[resourceType == "playlist"],
+			Duration:    msToDuration(item.Get(duration).Int()),
+			ID:          idFromURI(item.Get(trackURI).String()),
+			Artists:     names(item.Get(artistNames)),
+			AlbumArtist: strings.Join(names(albumOf.Get("artists.items.#.profile.name")), ", "),
+			AlbumID:     idFromURI(albumOf.Get("uri").String()),
+			TrackNumber: int(item.Get(trackNumber).Int()),
+			DiscNumber:  int(item.Get(discNumber).Int()),
+			ReleaseDate: releaseDate(albumOf.Get("date")),
+			Explicit:    isExplicit(item.Get(contentRating)),
 		}
+		track.setExternalIDs(item.Get(externalIDs))
+		track.setArtistIDs(item.Get(artistURIs))
+		track.setTotals(albumOf.Get("discs"), albumOf.Get("tracks.totalCount"))
+		track.setCovers(albumOf.Get("coverArt.sources"))
 
 		tracks = append(tracks, *track.buildTrack())
 	}
 
 	return tracks
 }
+
+/* spotify reports track lengths in milliseconds */
+func msToDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+const (
+	streamChunk        = 10 << 20         /* bytes per range request, youtube throttles bigger ones */
+	streamStallTimeout = 30 * time.Second /* without a byte for this long, the connection is dropped and the download resumed */
+)
+
+/*
+downloads the audio to <file>.<video>-<itag>.part and renames it once complete.
+an interrupted download (even from a previous run) is resumed with range requests,
+the parts of other videos or formats of the same file are discarded
+*/
+func downloadStream(client *youtube.Client, video *youtube.Video, format *youtube.Format, file string) error {
+	var url string
+	err := retryPolicy.Do(func() error {
+		var err error
+		requestLimiter.Wait()
+		url, err = client.GetStreamURL(video, format)
+		return classifyVideoError(err)
+	})
+	if err != nil {
+		return err
+	}
+
+	part := fmt.Sprintf("%s.%s-%d.part", file, video.ID, format.ItagNo)
+	removeStaleParts(file, part)
+
+	/* every attempt goes on from where the previous one stopped */
+	if err := retryPolicy.Do(func() error { return resumeStream(url, part, format.ContentLength) }); err != nil {
+		return err
+	}
+
+	size, err := GetFileSize(part)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 || (format.ContentLength > 0 && size != format.ContentLength) {
+		DeleteResource(part)
+		return fmt.Errorf("incomplete download: %d of %d bytes", size, format.ContentLength)
+	}
+
+	return os.Rename(part, file)
+}
+
+/* appends the rest of the stream to the part file, total is 0 when youtube doesn't tell the length */
+func resumeStream(url, part string, total int64) error {
+	file, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	/* a stalled connection would hold the download worker forever */
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stall := time.AfterFunc(streamStallTimeout, cancel)
+	defer stall.Stop()
+
+	/* left by a different stream */
+	if total > 0 && offset > total {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	for total == 0 || offset < total {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if total > 0 {
+			byteRange += fmt.Sprint(min(offset+streamChunk, total) - 1)
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", byteRange)
+
+		requestLimiter.Wait()
+
+		stall.Reset(streamStallTimeout)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return &RetryableError{Err: fmt.Errorf("error getting stream: %w", stalled(ctx, err))}
+		}
+
+		switch resp.StatusCode {
+		case http.StatusRequestedRangeNotSatisfiable: /* the length is unknown and the part may already be complete */
+			resp.Body.Close()
+			if total == 0 && offset > 0 && streamLength(resp) == offset {
+				return nil
+			}
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			return &RetryableError{Err: errors.New("the part doesn't match the stream, downloading it again")}
+		case http.StatusPartialContent:
+		case http.StatusOK: /* the whole stream, the range was ignored */
+			if err := file.Truncate(0); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			offset = 0
+		default:
+			resp.Body.Close()
+			return statusError(resp)
+		}
+
+		n, err := io.Copy(file, stallReader{resp.Body, stall})
+		resp.Body.Close()
+		offset += n
+		if err != nil {
+			return &RetryableError{Err: fmt.Errorf("error reading stream: %w", stalled(ctx, err))}
+		}
+
+		if total == 0 || resp.StatusCode == http.StatusOK {
+			break
+		}
+		if n == 0 {
+			return &RetryableError{Err: errors.New("empty stream response")}
+		}
+	}
+
+	return nil
+}
+
+/* the total length of a 416 response (its Content-Range), -1 if unknown */
+func streamLength(resp *http.Response) int64 {
+	var length int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &length); err != nil {
+		return -1
+	}
+
+	return length
+}
+
+/* parts of the same file left by another video or format, they can't be resumed */
+func removeStaleParts(file, part string) {
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return
+	}
+
+	prefix := filepath.Base(file) + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".part") && name != filepath.Base(part) {
+			os.Remove(filepath.Join(filepath.Dir(file), name))
+		}
+	}
+}
+
+/* puts off the stall timeout with every read */
+type stallReader struct {
+	io.Reader
+	stall *time.Timer
+}
+
+func (r stallReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.stall.Reset(streamStallTimeout)
+	}
+	return n, err
+}
+
+func stalled(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("no data for %s: %w", streamStallTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const syncManifestFile = ".goffy-sync.json"
+
+/* what a synced folder has, written after every sync */
+type SyncManifest struct {
+	mu     sync.Mutex
+	path   string
+	Source string               `json:"source"` /* the synced link */
+	Tracks map[string]SyncEntry `json:"tracks"` /* by spotify track ID */
+}
+
+type SyncEntry struct {
+	File    string    `json:"file"` /* relative to the folder */
+	VideoID string    `json:"videoId,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+/* set while syncing, dlTrack records every file in it */
+var syncManifest *SyncManifest
+
+func LoadSyncManifest(dir string) (*SyncManifest, error) {
+	manifest := &SyncManifest{path: filepath.Join(dir, syncManifestFile), Tracks: make(map[string]SyncEntry)}
+	data, err := os.ReadFile(manifest.path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %w", manifest.path, err)
+	}
+	if manifest.Tracks == nil {
+		manifest.Tracks = make(map[string]SyncEntry)
+	}
+
+	return manifest, nil
+}
+
+/* the video ID of a file that was already there is kept */
+func (m *SyncManifest) Put(spotifyID, file, videoID string) {
+	if m == nil || spotifyID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if videoID == "" {
+		videoID = m.Tracks[spotifyID].VideoID
+	}
+	m.Tracks[spotifyID] = SyncEntry{File: filepath.Base(file), VideoID: videoID, Time: time.Now()}
+}
+
+func (m *SyncManifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(m.path, append(data, '\n'), 0644)
+}
+
+/* tracks to download: new ones, and the ones whose file is gone */
+func (m *SyncManifest) missing(tracks []Track, dir string) []Track {
+	var missing []Track
+	for _, track := range tracks {
+		entry, ok := m.Tracks[track.ID]
+		if !ok {
+			missing = append(missing, track)
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, entry.File)); err != nil {
+			missing = append(missing, track)
+		}
+	}
+
+	return missing
+}
+
+/* entries whose track is no longer in the playlist */
+func (m *SyncManifest) removed(tracks []Track) []string {
+	current := make(map[string]bool)
+	for _, track := range tracks {
+		current[track.ID] = true
+	}
+
+	var removed []string
+	for id := range m.Tracks {
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	return removed
+}
+
+/*
+-prune deletes the file of a removed track, -archive moves it to another folder.
+a file can be shared by several tracks (same title and artist), it is kept while a track of the playlist has it
+*/
+func (m *SyncManifest) dropRemoved(ids []string, dir string) error {
+	removed := make(map[string]bool)
+	for _, id := range ids {
+		removed[id] = true
+	}
+
+	inUse := make(map[string]bool)
+	for id, entry := range m.Tracks {
+		if !removed[id] {
+			inUse[entry.File] = true
+		}
+	}
+
+	for _, id := range ids {
+		file := filepath.Join(dir, m.Tracks[id].File)
+		switch {
+		case (archiveF != "" || pruneF) && inUse[m.Tracks[id].File]:
+			fmt.Printf("'%s' was kept, another track of the playlist has it\n", m.Tracks[id].File)
+		case archiveF != "":
+			if err := os.MkdirAll(archiveF, 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(file, filepath.Join(archiveF, m.Tracks[id].File)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Printf("'%s' was archived\n", m.Tracks[id].File)
+		case pruneF:
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Printf("'%s' was deleted\n", m.Tracks[id].File)
+		default:
+			continue
+		}
+
+		delete(m.Tracks, id)
+	}
+
+	return nil
+}
+
+/* goffy sync <url> <folder>: downloads what was added since the last sync and handles what was removed */
+func runSyncCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: usage: goffy sync <playlist url> </path/to/folder/> [-prune | -archive /path/to/archive/]", errInvalidInput)
+	}
+
+	if pruneF && archiveF != "" {
+		return fmt.Errorf("%w: -prune and -archive can't be used together", errInvalidInput)
+	}
+
+	url, dir := args[0], args[1]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	manifest, err := LoadSyncManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest.Source != "" && manifest.Source != url {
+		yellow.Printf("The folder was synced with %s, now with %s\n", manifest.Source, url)
+	}
+	manifest.Source = url
+
+	tracks, err := ResourceTracks(url)
+	if err != nil {
+		return err
+	}
+
+	missing, removed := manifest.missing(tracks, dir), manifest.removed(tracks)
+	fmt.Printf("New tracks: %d, removed tracks: %d\n", len(missing), len(removed))
+
+	if err := manifest.dropRemoved(removed, dir); err != nil {
+		return err
+	}
+	if len(removed) > 0 && !pruneF && archiveF == "" {
+		fmt.Println("The files of the removed tracks were kept (use -prune to delete them or -archive to move them)")
+	}
+
+	if len(missing) > 0 {
+		syncManifest = manifest
+		defer func() {
+			syncManifest = nil
+		}()
+
+		fmt.Println("Now, downloading new tracks...")
+		if _, err := dlTrack(missing, filepath.Clean(dir)+string(filepath.Separator)); err != nil {
+			return err
+		}
+		totals.print()
+	}
+
+	return manifest.Save()
+}
@@ -64,7 +64,7 @@ func NewDir(path string) (string, error) {
 
 	err := os.Mkdir(fullPath, 0700)
 	if err != nil {
-		fmt.Sprintln("Error: %w", err)
+		fmt.Println("Error:", err)
 		return "", err
 	}
 
@@ -3,10 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/adrg/strutil"
-	"github.com/adrg/strutil/metrics"
 	"github.com/raitonoberu/ytmusic"
 	"github.com/tidwall/gjson"
 )
@@ -18,49 +20,146 @@ type TrackMatch struct {
 
 type YTResult struct {
 	Title, Artist, Album, Id string
+	Duration                 time.Duration
 }
 
 type Ratio struct {
-	Title, Artist, Album, Total float64
+	Title    float64 `json:"title"`
+	Artist   float64 `json:"artist"`
+	Album    float64 `json:"album"`
+	Duration float64 `json:"duration"`
+	Version  float64 `json:"version"` /* qualifierPenalty for each version qualifier (live, remix...) on only one title */
+	Total    float64 `json:"total"`
+}
+
+/* a paginated YouTube Music search, replaced by recorded responses in the evaluation harness */
+type trackSearcher interface {
+	NextExists() bool
+	Next() (*ytmusic.SearchResult, error)
+}
+
+var newTrackSearch = func(query string) trackSearcher {
+	return retryingSearch{ytmusic.TrackSearch(query)} /* github.com/raitonoberu/ytmusic */
+}
+
+/* how many results of each YouTube Music page are scored and how many pages are requested per query */
+var (
+	searchCandidates = 2
+	searchPages      = 2
+)
+
+/* candidates whose length differs more than this from the Spotify track are rejected (extended mixes, snippets...) */
+const maxDurationDiff = 15 * time.Second
+
+/* what happened while searching a Spotify track on YouTube Music (see -explain) */
+type MatchReport struct {
+	Track    Track
+	Searches []SearchReport
+	Id       string    /* the chosen video, empty if no candidate passed */
+	Ratio    float64   /* total ratio of the chosen candidate */
+	Path     MatchPath /* how the video was chosen */
+	Skipped  bool      /* the overrides file says the track must not be downloaded */
+}
+
+type MatchPath string
+
+const (
+	PathOverride MatchPath = "override" /* the overrides file */
+	PathCache    MatchPath = "cache"    /* a previous run (see cache.go) */
+	PathISRC     MatchPath = "isrc"     /* the recording found by searching its ISRC */
+	PathSearch   MatchPath = "search"   /* fuzzy matching of the title/artist/album searches */
+	PathManual   MatchPath = "manual"   /* picked by the user in -interactive mode */
+)
+
+type SearchReport struct {
+	Query      string
+	Page       int
+	Candidates []Candidate
 }
 
 /* select the best result on YouTube Music */
 func Match(results []YTResult, spTrack *Track) string {
+	return bestMatch(matcher.Rank(spTrack, results)).Id
+}
+
+func bestMatch(candidates []Candidate) TrackMatch {
 	var trackMatch TrackMatch
-	spTrack.Title = RemoveAccents(strings.ToLower(spTrack.Title))
-	spTrack.Artist = RemoveAccents(strings.ToLower(spTrack.Artist))
-	spTrack.Album = RemoveAccents(strings.ToLower(spTrack.Album))
-
-	for _, result := range results {
-		ratio := calculateMatchRatio(spTrack, result)
-		if ratio > trackMatch.Ratio && isPartialMatch(result, spTrack) {
-			trackMatch.Id = result.Id
-			trackMatch.Ratio = ratio
+
+	for _, candidate := range candidates {
+		if candidate.Valid() && candidate.Ratio.Total > trackMatch.Ratio {
+			trackMatch.Id = candidate.Result.Id
+			trackMatch.Ratio = candidate.Ratio.Total
 		}
 	}
 
-	return trackMatch.Id
+	return trackMatch
 }
 
-func calculateMatchRatio(spTrack *Track, result YTResult) float64 {
+/* weighted average of the similarities between both tracks (expects a lowercased, accentless spTrack) */
+func calculateMatchRatio(spTrack *Track, result YTResult, metric strutil.StringMetric, weights Weights) Ratio {
 	var ratio Ratio
-	
-	ratio.Title = map[bool]float64{result.Title != "": strutil.Similarity(result.Title, spTrack.Title, metrics.NewLevenshtein()), true: 0}[true]
-	ratio.Artist = map[bool]float64{result.Artist != "" && strings.Contains(CleanAndNormalize(result.Artist), CleanAndNormalize(spTrack.Artist)): strutil.Similarity(result.Artist, spTrack.Artist, metrics.NewLevenshtein()), true: 0}[true]
-	ratio.Album = map[bool]float64{result.Album == result.Title && result.Album == spTrack.Title: 1, true: strutil.Similarity(result.Album, spTrack.Album, metrics.NewLevenshtein())}[true]
-	ratio.Total = (ratio.Title + ratio.Artist + ratio.Album) / 3
 
-	return ratio.Total
+	if result.Title != "" {
+		ratio.Title = strutil.Similarity(result.Title, spTrack.Title, metric)
+	}
+	if result.Artist != "" && strings.Contains(CleanAndNormalize(result.Artist), CleanAndNormalize(spTrack.Artist)) {
+		ratio.Artist = strutil.Similarity(result.Artist, spTrack.Artist, metric)
+	}
+	if result.Album == result.Title && result.Album == spTrack.Title {
+		ratio.Album = 1 /* singles are usually named after the track */
+	} else {
+		ratio.Album = strutil.Similarity(result.Album, spTrack.Album, metric)
+	}
+
+	total := ratio.Title*weights.Title + ratio.Artist*weights.Artist + ratio.Album*weights.Album
+	totalWeight := weights.Title + weights.Artist + weights.Album
+
+	/* the duration only counts when both platforms report it */
+	if result.Duration > 0 && spTrack.Duration > 0 {
+		ratio.Duration = 1 - math.Min(float64(durationDiff(result, spTrack))/float64(maxDurationDiff), 1)
+		total += ratio.Duration * weights.Duration
+		totalWeight += weights.Duration
+	}
+
+	if totalWeight > 0 {
+		ratio.Total = total / totalWeight
+	}
+
+	ratio.Version = math.Pow(qualifierPenalty, float64(len(qualifierMismatches(result.Title, spTrack.Title))))
+	ratio.Total *= ratio.Version
+
+	return ratio
 }
 
-/* last validation before returning the most precise ID from the Match function */
+/* rejects candidates that are clearly another version of the track (9-minute extended mix, 30-second snippet) */
+func isDurationMatch(result YTResult, spTrack *Track) bool {
+	if result.Duration == 0 || spTrack.Duration == 0 {
+		return true
+	}
+
+	return durationDiff(result, spTrack) <= maxDurationDiff
+}
+
+func durationDiff(result YTResult, spTrack *Track) time.Duration {
+	diff := result.Duration - spTrack.Duration
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff
+}
+
+/*
+last validation before returning the most precise ID from the Match function:
+the titles share a whole word ("demo" is not in "demons")
+*/
 func isPartialMatch(result YTResult, spTrack *Track) bool {
 	ytTitle, spTitle := RemoveAccents(strings.ToLower(result.Title)), RemoveAccents(strings.ToLower(spTrack.Title))
-	ytTitleSeparated, spTitleSeparated := strings.Fields(ytTitle), strings.Fields(spTitle)
+	ytTitleSeparated, spTitleSeparated := titleWords(ytTitle), titleWords(spTitle)
 
 	for _, ytField := range ytTitleSeparated {
 		for _, spField := range spTitleSeparated {
-			if strings.Contains(ytField, spField) {
+			if ytField == spField {
 				return true
 			}
 		}
@@ -69,11 +168,17 @@ func isPartialMatch(result YTResult, spTrack *Track) bool {
 	return false
 }
 
-/* construct each YouTube result into a structured track and return a two-element slice */
-func (yt YTResult) buildResults(jsonResponse string) []YTResult {
+/* "(feat. someone)" -> ["feat", "someone"] */
+func titleWords(title string) []string {
+	return strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+/* construct each YouTube result into a structured track and return the first limit of them */
+func (yt YTResult) buildResults(jsonResponse string, limit int) []YTResult {
 	var ytResults []YTResult
 	jsonResults := gjson.Get(jsonResponse, "tracks").Array()
-	limit := 2
 
 	for _, result := range jsonResults {
 		if len(ytResults) >= limit {
@@ -84,12 +189,14 @@ func (yt YTResult) buildResults(jsonResponse string) []YTResult {
 		artist := result.Get("artists.#.name").String()
 		album := result.Get("album.name").String()
 		id := result.Get("videoId").String()
+		duration := result.Get("duration").Int() /* seconds */
 
 		item := YTResult{
-			Title:  RemoveAccents(strings.ToLower(title)),
-			Artist: RemoveAccents(strings.ToLower(artist)),
-			Album:  RemoveAccents(strings.ToLower(album)),
-			Id:     id,
+			Title:    RemoveAccents(strings.ToLower(title)),
+			Artist:   RemoveAccents(strings.ToLower(artist)),
+			Album:    RemoveAccents(strings.ToLower(album)),
+			Id:       id,
+			Duration: time.Duration(duration) * time.Second,
 		}
 
 		ytResults = append(ytResults, item)
@@ -98,18 +205,111 @@ func (yt YTResult) buildResults(jsonResponse string) []YTResult {
 	return ytResults
 }
 
+/* queries tried in order until one of them returns a valid candidate */
+func searchQueries(spTrack Track) []string {
+	queries := []string{fmt.Sprintf("'%s' %s %s", spTrack.Title, spTrack.Artist, spTrack.Album)}
+	if spTrack.Album != "" {
+		queries = append(queries, fmt.Sprintf("%s %s", spTrack.Title, spTrack.Artist)) /* the album sometimes hides the right song */
+	}
+
+	return queries
+}
+
 func VideoID(spTrack Track) (string, error) {
-	var ytResult YTResult
-	query := fmt.Sprintf("'%s' %s %s", spTrack.Title, spTrack.Artist, spTrack.Album)
-	search := ytmusic.TrackSearch(query)                                             /* github.com/raitonoberu/ytmusic */
-	result, err := search.Next()
+	report, err := MatchTrack(spTrack)
 	if err != nil {
 		return "", err
 	}
 
+	return report.Id, nil
+}
+
+/* searches spTrack on YouTube Music and records every candidate considered */
+func MatchTrack(spTrack Track) (*MatchReport, error) {
+	var lastErr error
+	report := &MatchReport{Track: spTrack}
+
+	if id, ok := lookupOverride(spTrack); ok {
+		report.Path = PathOverride
+		report.Skipped = id == skipOverride
+		if !report.Skipped {
+			report.Id = id
+		}
+		return report, nil
+	}
+
+	if cached, ok := getMatchCache().Get(spTrack.ID); ok && !refreshMatches {
+		report.Id, report.Ratio, report.Path = cached.VideoID, cached.Ratio, PathCache
+		return report, nil
+	}
+
+	/* exact recording first, fuzzy matching only when it is not found */
+	if spTrack.ISRC != "" {
+		if err := matchISRC(spTrack, report); err == nil && report.Id != "" {
+			return report, nil
+		}
+	}
+
+	for _, query := range searchQueries(spTrack) {
+		search := newTrackSearch(query)
+
+		/* the next page is only requested when no candidate of the previous one passed */
+		for page := 1; page <= searchPages && search.NextExists(); page++ {
+			result, err := search.Next()
+			if err != nil {
+				lastErr = err
+				break
+			}
+
+			candidates := rankPage(&spTrack, result, searchCandidates)
+			report.Searches = append(report.Searches, SearchReport{Query: query, Page: page, Candidates: candidates})
+
+			if trackMatch := bestMatch(candidates); trackMatch.Id != "" {
+				report.Id, report.Ratio, report.Path = trackMatch.Id, trackMatch.Ratio, PathSearch
+				return report, nil
+			}
+		}
+	}
+
+	if len(report.Searches) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return report, nil
+}
+
+/*
+YouTube Music indexes the ISRC of its "song" results, so searching it returns the
+same recording first. it is only trusted if it also looks like the Spotify track
+(title, duration and version), the ratio doesn't matter here
+*/
+func matchISRC(spTrack Track, report *MatchReport) error {
+	result, err := newTrackSearch(spTrack.ISRC).Next()
+	if err != nil {
+		return err
+	}
+
+	candidates := rankPage(&spTrack, result, searchCandidates)
+	report.Searches = append(report.Searches, SearchReport{Query: spTrack.ISRC, Page: 1, Candidates: candidates})
+
+	if len(result.Tracks) == 0 {
+		return nil
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Result.Id == result.Tracks[0].VideoID && candidate.Partial && candidate.InRange && !candidate.Blocked {
+			report.Id, report.Ratio, report.Path = candidate.Result.Id, candidate.Ratio.Total, PathISRC
+		}
+	}
+
+	return nil
+}
+
+/* scores the first limit results of a YouTube Music page */
+func rankPage(spTrack *Track, result *ytmusic.SearchResult, limit int) []Candidate {
+	var ytResult YTResult
 	jsonStr, _ := json.Marshal(result)
-	ytResults := ytResult.buildResults(string(jsonStr))
-	id := Match(ytResults, &spTrack)
+	ytResults := ytResult.buildResults(string(jsonStr), limit)
 
-	return id, nil
+	return matcher.Rank(spTrack, ytResults)
 }